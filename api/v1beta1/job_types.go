@@ -55,6 +55,41 @@ type JobConfig struct {
 	ExecOnFailure string          `json:"execOnFailure,omitempty"`
 	SizePattern   string          `json:"sizePattern,omitempty"`
 	AdditionEnvs  []corev1.EnvVar `json:"additionEnvs,omitempty"`
+	// RetryInterval is the base backoff in seconds before retrying a failed sync,
+	// doubled on every consecutive failure. Zero leaves retries to the interval.
+	RetryInterval int `json:"retryInterval,omitempty"`
+	// MaxRetries is the max consecutive failures retried with backoff, zero means no limit
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// BandwidthLimit caps the sync in bytes per second, zero means no limit
+	BandwidthLimit int64 `json:"bandwidthLimit,omitempty"`
+	// DependsOn are the jobs which must have synced before this job starts
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// CallbackTimeout is the seconds to wait for the worker to ack a
+	// command, the manager default when zero, at most 30
+	CallbackTimeout int `json:"callbackTimeout,omitempty"`
+	// PublicURL is where users download from, /<name> when empty, while
+	// Upstream is where the mirror syncs from. It replaces Url, which is
+	// only read when PublicURL is empty.
+	PublicURL string `json:"publicUrl,omitempty"`
+	// SyncAt are the clock times of the worker to sync at, separated by
+	// ";", e.g. 03:00;15:00, instead of every Interval after the last sync
+	SyncAt string `json:"syncAt,omitempty"`
+	// SyncJitter is the max seconds a scheduled sync is randomly delayed,
+	// so mirrors sharing a time don't start all at once
+	SyncJitter int `json:"syncJitter,omitempty"`
+	// Owner is the team owning the mirror, Contact how to reach it, e.g.
+	// an email or chat channel, both shown to whoever handles its alerts
+	Owner   string `json:"owner,omitempty"`
+	Contact string `json:"contact,omitempty"`
+	// Args are appended to Command of the command provider as they are,
+	// unlike Command they are not split, so an argument may hold spaces
+	Args []string `json:"args,omitempty"`
+	// UpstreamMarker is the url of a timestamp file of the upstream, e.g.
+	// project/trace of a debian mirror, which tells how far the mirror
+	// lags behind. It must be on the host of the upstream.
+	UpstreamMarker string `json:"upstreamMarker,omitempty"`
+	// Note is a free-text maintenance note, e.g. why the mirror is disabled
+	Note string `json:"note,omitempty"`
 	// Why this is a string? It's a feature! Maybe you can write debug reason here as long as it's not empty. :)
 	Debug string `json:"debug,omitempty"`
 }
@@ -97,8 +132,20 @@ const (
 	Disabled   SyncStatus = "disabled"
 	Cached     SyncStatus = "cached"
 	Created    SyncStatus = "created"
+	// Offline is set by a worker shutting down cleanly
+	Offline SyncStatus = "offline"
+	// Unknown replaces a status reported which is none of the above
+	Unknown SyncStatus = "unknown"
 )
 
+// SyncRecord is the outcome of a finished sync
+type SyncRecord struct {
+	Time     int64      `json:"time"`
+	Status   SyncStatus `json:"status"`
+	Duration int64      `json:"duration"`
+	Size     uint64     `json:"size"`
+}
+
 // JobStatus defines the observed state of Job
 type JobStatus struct {
 	Status       SyncStatus `json:"status"`
@@ -111,6 +158,32 @@ type JobStatus struct {
 	ErrorMsg     string     `json:"errorMsg"`
 	LastOnline   int64      `json:"lastOnline"`
 	LastRegister int64      `json:"lastRegister"`
+	// ConsecutiveFailures counts the failed syncs since the last success
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+	// NextRetry is the recommended time to retry a failed sync
+	NextRetry int64 `json:"nextRetry,omitempty"`
+	// History keeps the latest finished syncs, oldest first
+	History []SyncRecord `json:"history,omitempty"`
+	// LogTail is the tail of the latest sync log reported by the worker
+	LogTail string `json:"logTail,omitempty"`
+	// BoostUntil is when a boosted job is back to the interval of its
+	// spec, zero when not boosted. The worker syncs every BoostInterval
+	// minutes meanwhile.
+	BoostUntil    int64 `json:"boostUntil,omitempty"`
+	BoostInterval int   `json:"boostInterval,omitempty"`
+	// DiskSize is the bytes the mirror takes on disk, filesystem overhead
+	// included, while Size is the apparent size of its files
+	DiskSize uint64 `json:"diskSize,omitempty"`
+	// CompressedSize is the bytes of the data of a mirror storing it
+	// compressed, Size being its uncompressed size
+	CompressedSize uint64 `json:"compressedSize,omitempty"`
+	// TokenHash is the sha256 of the token the worker authenticates with,
+	// written by the manager on rotation only. The worker routes are open
+	// when empty.
+	TokenHash string `json:"tokenHash,omitempty"`
+	// ReportedAt is the unix milliseconds the worker sent the status at,
+	// by the clock of the worker
+	ReportedAt int64 `json:"reportedAt,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -239,13 +239,17 @@ func (r *JobReconciler) desiredDeployment(job *v1beta1.Job, manager string, fron
 			{Name: "RETRY", Value: strconv.Itoa(job.Spec.Config.Retry)},
 			{Name: "TIMEOUT", Value: strconv.Itoa(job.Spec.Config.Timeout)},
 			{Name: "COMMAND", Value: job.Spec.Config.Command},
+			{Name: "ARGS", Value: strings.Join(job.Spec.Config.Args, ";")},
 			{Name: "FAIL_ON_MATCH", Value: job.Spec.Config.FailOnMatch},
 			{Name: "SIZE_PATTERN", Value: job.Spec.Config.SizePattern},
 			{Name: "IPV6", Value: job.Spec.Config.IPv6Only},
 			{Name: "IPV4", Value: job.Spec.Config.IPv4Only},
 			{Name: "EXCLUDE_FILE", Value: job.Spec.Config.ExcludeFile},
 			{Name: "RSYNC_OPTIONS", Value: job.Spec.Config.RsyncOptions},
+			{Name: "BANDWIDTH_LIMIT", Value: strconv.FormatInt(job.Spec.Config.BandwidthLimit, 10)},
 			{Name: "STAGE1_PROFILE", Value: job.Spec.Config.Stage1Profile},
+			{Name: "SYNC_AT", Value: job.Spec.Config.SyncAt},
+			{Name: "SYNC_JITTER", Value: strconv.Itoa(job.Spec.Config.SyncJitter)},
 			{Name: "EXEC_ON_SUCCESS", Value: job.Spec.Config.ExecOnSuccess},
 			{Name: "EXEC_ON_FAILURE", Value: job.Spec.Config.ExecOnFailure},
 			{Name: "API", Value: fmt.Sprintf("http://%s:3000", manager)},
@@ -81,6 +81,14 @@ func (r *ManagerReconciler) desiredRole(manager *v1beta1.Manager) (*v1.Role, err
 				APIGroups: []string{v1beta1.GroupVersion.Group}, Resources: []string{"files/status"},
 				Verbs: []string{"get", "patch", "update"},
 			},
+			{
+				APIGroups: []string{corev1.GroupName}, Resources: []string{"configmaps"},
+				Verbs: []string{"create", "get", "list", "update", "watch"},
+			},
+			{
+				APIGroups: []string{corev1.GroupName}, Resources: []string{"events"},
+				Verbs: []string{"create", "list", "patch"},
+			},
 		},
 	}
 
@@ -2,12 +2,20 @@ package internal
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
@@ -18,6 +26,9 @@ const (
 	T = 1024 * G
 )
 
+// MaxLogTail is the max size of the log tail stored in job status
+const MaxLogTail = 64 * K
+
 type AnnouncementInfo struct {
 	ID      string `json:"id"`
 	Title   string `json:"title"`
@@ -49,18 +60,329 @@ type MirrorStatus struct {
 	HelpUrl string             `json:"helpUrl"`
 	Type    v1beta1.MirrorType `json:"type"`
 	SizeStr string             `json:"sizeStr"`
+	Note    string             `json:"note,omitempty"`
+	// DiskSizeStr is DiskSize of the status, when reported
+	DiskSizeStr string `json:"diskSizeStr,omitempty"`
+	// CompressedSizeStr is CompressedSize of the status, when reported
+	CompressedSizeStr string `json:"compressedSizeStr,omitempty"`
+	// PublicURL is where users download from, Url is the same for old
+	// clients. UpstreamURL is where the mirror syncs from.
+	PublicURL   string `json:"publicUrl"`
+	UpstreamURL string `json:"upstreamUrl"`
+	// Uptime is the seconds since the worker registered, a worker
+	// restarting often keeps it low
+	Uptime int64 `json:"uptime,omitempty"`
+	// Created is the unix time the mirror was created at
+	Created int64 `json:"created,omitempty"`
+	// Owner and Contact route the alerts of the mirror
+	Owner   string `json:"owner,omitempty"`
+	Contact string `json:"contact,omitempty"`
+	// ResourceVersion of the job, sent back as If-Match to write only
+	// if the job is unchanged
+	ResourceVersion string `json:"resourceVersion,omitempty"`
 
 	v1beta1.JobStatus
 }
 
+// StaleMirror is a mirror overdue for sync
+type StaleMirror struct {
+	MirrorStatus
+	// Staleness is the seconds since LastUpdate, or since the job was
+	// created when it never synced
+	Staleness   int64 `json:"staleness"`
+	NeverSynced bool  `json:"neverSynced"`
+}
+
 type MirrorConfig struct {
 	ID string `json:"id"`
 
 	v1beta1.JobSpec
 }
 
+// ErrorCode tells the kind of an error response of the manager
+type ErrorCode string
+
+const (
+	ErrNotFound     ErrorCode = "NotFound"
+	ErrConflict     ErrorCode = "Conflict"
+	ErrInvalid      ErrorCode = "Invalid"
+	ErrUnauthorized ErrorCode = "Unauthorized"
+	ErrUnavailable  ErrorCode = "Unavailable"
+	ErrInternal     ErrorCode = "Internal"
+)
+
+// Maintenance is a window in which no sync starts
+type Maintenance struct {
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Reason string `json:"reason"`
+	Active bool   `json:"active"`
+}
+
+// Scheduling tells whether new syncs are paused fleet-wide
+type Scheduling struct {
+	Paused bool   `json:"paused"`
+	Since  int64  `json:"since,omitempty"`
+	By     string `json:"by,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// DefaultInterval is the interval in minutes of mirrors setting none
+type DefaultInterval struct {
+	Interval int `json:"interval"`
+}
+
+// CacheStatus is the sync state of the job cache of the manager
+type CacheStatus struct {
+	Synced     bool  `json:"synced"`
+	Started    int64 `json:"started"`
+	SyncedAt   int64 `json:"syncedAt"`
+	LastResync int64 `json:"lastResync"`
+}
+
+// BulkCmdResult reports the mirrors a command was sent to
+type BulkCmdResult struct {
+	Sent   []string          `json:"sent"`
+	Failed map[string]string `json:"failed"`
+}
+
+// MirrorLag compares the upstream marker of a mirror with its LastUpdate
+type MirrorLag struct {
+	ID     string `json:"id"`
+	Marker string `json:"marker"`
+	// UpstreamTime is when the upstream last changed as told by Marker
+	UpstreamTime int64 `json:"upstreamTime"`
+	LastUpdate   int64 `json:"lastUpdate"`
+	// Lag is the seconds the upstream changed after LastUpdate, zero
+	// unless Behind, or when the mirror never synced
+	Lag    int64 `json:"lag"`
+	Behind bool  `json:"behind"`
+}
+
+// DrainMove is a mirror moved off a draining node, To is empty when it's
+// no longer pinned to any
+type DrainMove struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DrainPlan reports the mirrors moved off the draining node Worker
+type DrainPlan struct {
+	Worker string            `json:"worker"`
+	Moves  []DrainMove       `json:"moves"`
+	Failed map[string]string `json:"failed"`
+}
+
+// CmdAssignment reports the node a mirror was pinned to by a command
+// posted to /jobs/cmd?assign=true, From is the node it was pinned to
+// before and Load the syncs running on Node when it was chosen
+type CmdAssignment struct {
+	ID   string `json:"id"`
+	Node string `json:"node"`
+	From string `json:"from,omitempty"`
+	Load int    `json:"load"`
+}
+
+// JobSummary counts the mirrors by status
+type JobSummary struct {
+	Total    int                        `json:"total"`
+	Statuses map[v1beta1.SyncStatus]int `json:"statuses"`
+	Size     uint64                     `json:"size"`
+	SizeStr  string                     `json:"sizeStr"`
+	// Stale is the count of mirrors /jobs/stale would list
+	Stale int `json:"stale"`
+	// DiskSize sums the disk usage of the mirrors reporting it, Size
+	// their apparent sizes
+	DiskSize    uint64 `json:"diskSize"`
+	DiskSizeStr string `json:"diskSizeStr"`
+	// CompressedSize sums the compressed sizes of the mirrors reporting
+	// them
+	CompressedSize    uint64 `json:"compressedSize"`
+	CompressedSizeStr string `json:"compressedSizeStr"`
+	// Usage is the storage the mirrors consume, their disk usage when
+	// reported, else their compressed or their apparent size
+	Usage    uint64 `json:"usage"`
+	UsageStr string `json:"usageStr"`
+}
+
+// DependencyGraph maps every mirror to the mirrors it depends on
+type DependencyGraph map[string][]string
+
+// BulkDeleteResult reports the mirrors deleted at once
+type BulkDeleteResult struct {
+	Deleted []string          `json:"deleted"`
+	Failed  map[string]string `json:"failed"`
+}
+
+// VersionInfo is the build info of the manager
+type VersionInfo struct {
+	Version    string `json:"version"`
+	GitCommit  string `json:"gitCommit"`
+	BuildDate  string `json:"buildDate"`
+	GoVersion  string `json:"goVersion"`
+	APIVersion string `json:"apiVersion"`
+}
+
+// Capabilities tells workers and clients what the manager supports, so
+// they can adapt to an older or newer manager
+type Capabilities struct {
+	APIVersion string        `json:"apiVersion"`
+	Commands   []CmdVerbInfo `json:"commands"`
+	Features   Features      `json:"features"`
+}
+
+// Features are the optional behaviors of the manager, a feature unknown to
+// the manager is absent and so false
+type Features struct {
+	// AdminAuth is set when the admin routes require a token
+	AdminAuth bool `json:"adminAuth"`
+	// WorkerTokens is set when reports of mirrors with an issued token
+	// require it
+	WorkerTokens bool `json:"workerTokens"`
+	// ManagerSchedules is set when the manager schedules the syncs,
+	// otherwise workers schedule them and report the next one
+	ManagerSchedules bool `json:"managerSchedules"`
+	// GracefulStop is set when a stop may let the running sync finish
+	GracefulStop bool `json:"gracefulStop"`
+	// Heartbeats is set when workers are pinged and set offline when
+	// they don't answer
+	Heartbeats bool `json:"heartbeats"`
+	// SignedCmds is set when command posts must be signed, see SignCmd
+	SignedCmds bool `json:"signedCmds"`
+	// ReadOnly is set when the manager rejects every write, workers must
+	// report to another one
+	ReadOnly bool `json:"readOnly"`
+}
+
+// MirrorImportResult reports what an import did to every mirror
+type MirrorImportResult struct {
+	Created []string          `json:"created"`
+	Updated []string          `json:"updated"`
+	Skipped []string          `json:"skipped"`
+	Failed  map[string]string `json:"failed"`
+}
+
+type SizePoint struct {
+	Time int64  `json:"time"`
+	Size uint64 `json:"size"`
+}
+
+type SizeTrend struct {
+	ID      string      `json:"id"`
+	Points  []SizePoint `json:"points"`
+	Delta   int64       `json:"delta"`
+	Percent float64     `json:"percent"`
+	// Shrunk is set when the size dropped more than the threshold,
+	// which usually means a broken upstream
+	Shrunk bool `json:"shrunk"`
+}
+
+// MirrorEvent is a kubernetes event of a mirror, times in unix seconds
+type MirrorEvent struct {
+	Type    string `json:"type"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Count   int32  `json:"count"`
+	// First is when the event first happened, Time the last
+	First int64 `json:"first"`
+	Time  int64 `json:"time"`
+}
+
+// MirrorEvents is a page of the events of a mirror, latest first
+type MirrorEvents struct {
+	ID     string        `json:"id"`
+	Total  int           `json:"total"`
+	Events []MirrorEvent `json:"events"`
+}
+
 type MirrorSchedule struct {
 	NextSchedule int64 `json:"next_schedule"`
+	// NextScheduleTime is NextSchedule in RFC3339, set only when a tz is requested
+	NextScheduleTime string `json:"next_schedule_time,omitempty"`
+}
+
+type MirrorNote struct {
+	Note string `json:"note"`
+}
+
+// MirrorToken is a newly issued worker token, only returned once
+type MirrorToken struct {
+	Token string `json:"token"`
+}
+
+// MirrorBoost syncs a mirror more often for a while
+type MirrorBoost struct {
+	// Interval in minutes while boosted
+	Interval int `json:"interval"`
+	// Duration of the boost, e.g. 6h
+	Duration string `json:"duration"`
+	// Until is when the boost ends, only in responses
+	Until int64 `json:"until,omitempty"`
+}
+
+// MirrorClone names the copy of a mirror
+type MirrorClone struct {
+	ID string `json:"id"`
+}
+
+type MirrorForceStatus struct {
+	Status v1beta1.SyncStatus `json:"status"`
+	Reason string             `json:"reason"`
+}
+
+// Defaults of a worker for the config its mirror leaves unset
+const (
+	DefaultProvider   = "rsync"
+	DefaultConcurrent = 3
+	DefaultRetry      = 2
+	// DefaultMirrorDir holds the data of a mirror in a directory named
+	// after it, unless JobConfig.MirrorPath is set
+	DefaultMirrorDir = "/data"
+)
+
+// ClockLayout is the layout of the clock times of JobConfig.SyncAt
+const ClockLayout = "15:04"
+
+// SyncStatuses are all the known sync statuses
+var SyncStatuses = []v1beta1.SyncStatus{
+	v1beta1.None, v1beta1.Failed, v1beta1.Success, v1beta1.Syncing, v1beta1.PreSyncing,
+	v1beta1.Paused, v1beta1.Disabled, v1beta1.Cached, v1beta1.Created, v1beta1.Offline,
+	v1beta1.Unknown,
+}
+
+func IsSyncStatus(s v1beta1.SyncStatus) bool {
+	for _, v := range SyncStatuses {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxMirrorIDLength leaves room for the suffixes of the objects of a
+// mirror, e.g. <id>-front
+const MaxMirrorIDLength = validation.DNS1123LabelMaxLength - len("-front")
+
+// NormalizeMirrorID lowercases id and trims the spaces around it
+func NormalizeMirrorID(id string) string {
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// ValidateMirrorID returns why id can't be a mirror ID, nil if it can. A
+// mirror ID names the kubernetes objects of the mirror, so it must be a
+// DNS-1123 label short enough for their suffixes.
+func ValidateMirrorID(id string) error {
+	if id == "" {
+		return errors.New("mirror id required")
+	}
+	if len(id) > MaxMirrorIDLength {
+		return fmt.Errorf("invalid mirror id %s: must be no more than %d characters", id, MaxMirrorIDLength)
+	}
+	if errs := validation.IsDNS1123Label(id); len(errs) > 0 {
+		return fmt.Errorf("invalid mirror id %s: %s", id, strings.Join(errs, ", "))
+	}
+	return nil
 }
 
 // A CmdVerb is an action to a job or worker
@@ -77,14 +399,34 @@ const (
 	CmdPing
 	// CmdUpdate update size
 	CmdUpdate
+	// CmdDisable stop syncing, and disable the job
+	CmdDisable
 )
 
+// CmdVerbInfo describes a CmdVerb for clients
+type CmdVerbInfo struct {
+	Cmd  CmdVerb `json:"cmd"`
+	Desc string  `json:"desc"`
+}
+
+// CmdVerbs are all the known verbs, in the order of their values
+var CmdVerbs = []CmdVerbInfo{
+	{CmdStart, "start a job"},
+	{CmdStop, "stop syncing, but keep the job"},
+	{CmdRestart, "restart a syncing job"},
+	{CmdPing, "ensures the goroutine is alive"},
+	{CmdUpdate, "update size"},
+	{CmdDisable, "stop syncing, and disable the job"},
+}
+
 func (c CmdVerb) String() string {
 	mapping := map[CmdVerb]string{
 		CmdStart:   "start",
 		CmdStop:    "stop",
 		CmdRestart: "restart",
 		CmdPing:    "ping",
+		CmdUpdate:  "update",
+		CmdDisable: "disable",
 	}
 	return mapping[c]
 }
@@ -95,6 +437,8 @@ func NewCmdVerbFromString(s string) CmdVerb {
 		"stop":    CmdStop,
 		"restart": CmdRestart,
 		"ping":    CmdPing,
+		"update":  CmdUpdate,
+		"disable": CmdDisable,
 	}
 	return mapping[s]
 }
@@ -113,15 +457,93 @@ func (s *CmdVerb) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err
 	}
-	*s = NewCmdVerbFromString(j)
-	return nil
+	// an unknown verb must not become CmdStart
+	if v := NewCmdVerbFromString(j); v.String() == j {
+		*s = v
+		return nil
+	}
+	return fmt.Errorf("unknown command: %s", j)
 }
 
+// StopMode tells how CmdStop treats a running sync
+type StopMode string
+
+const (
+	// StopTerminate kills a running sync, the job is paused at once
+	StopTerminate StopMode = "terminate"
+	// StopGraceful lets a running sync finish and report success or
+	// failure, then the job is paused instead of scheduled again. A job
+	// not syncing is paused at once.
+	StopGraceful StopMode = "graceful"
+)
+
 // A ClientCmd is the command message send from client
 // to the manager
 type ClientCmd struct {
 	Cmd   CmdVerb `json:"cmd"`
 	Force bool    `json:"force"`
+	// Mode of CmdStop, StopTerminate when empty
+	Mode StopMode `json:"mode,omitempty"`
+}
+
+// Headers of a signed command, see SignCmd
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Signature-Timestamp"
+	NonceHeader     = "X-Signature-Nonce"
+)
+
+// SignCmd returns the hex HMAC-SHA256 of a command post keyed by the
+// command secret of the manager. The timestamp is in unix seconds and the
+// nonce is unique per request.
+func SignCmd(key []byte, method, path, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the signature headers of a command post with body,
+// stamped now and with a random nonce
+func SignRequest(req *http.Request, key, body []byte) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(b)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, SignCmd(key, req.Method, req.URL.Path, timestamp, nonce, body))
+	return nil
+}
+
+// CmdAckOK is the message of a CmdAck accepting the command
+const CmdAckOK = "OK"
+
+// PingResult is the response to a ping command, a successful ping
+// bumps the LastOnline of the mirror
+type PingResult struct {
+	// RoundTrip is the milliseconds the worker took to ack
+	RoundTrip  int64 `json:"roundTrip"`
+	LastOnline int64 `json:"lastOnline"`
+}
+
+// A CmdAck is the response of the worker to a ClientCmd
+type CmdAck struct {
+	Msg string `json:"msg"`
+}
+
+// TruncateLogTail keeps at most max bytes of log, dropping the oldest lines
+func TruncateLogTail(log string, max int) string {
+	if len(log) <= max {
+		return log
+	}
+	log = log[len(log)-max:]
+	if i := strings.IndexByte(log, '\n'); i >= 0 {
+		log = log[i+1:]
+	}
+	return log
 }
 
 func ParseSize(size uint64) (sizeStr string) {
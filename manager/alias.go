@@ -0,0 +1,120 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resolveAlias rewrites an alias in :id to the name of its job, so that
+// the routes for humans accept both
+func (m *Manager) resolveAlias(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	err := m.client.Get(ctx, client.ObjectKey{Name: id}, new(v1beta1.Job))
+	if err == nil || !apierrors.IsNotFound(err) {
+		// an unknown error is left to the handler
+		c.Next()
+		return
+	}
+
+	jobs := new(v1beta1.JobList)
+	if err = m.client.List(ctx, jobs); err != nil {
+		c.Next()
+		return
+	}
+	for _, v := range jobs.Items {
+		if v.Spec.Config.Alias == id {
+			setParam(c, "id", v.Name)
+			break
+		}
+	}
+	c.Next()
+}
+
+// setParam replaces the value of the path param key
+func setParam(c *gin.Context, key, value string) {
+	for i := range c.Params {
+		if c.Params[i].Key == key {
+			c.Params[i].Value = value
+		}
+	}
+}
+
+// validMirrorID normalizes the :id of the mirror routes, and responds 400
+// if it's no valid mirror ID. An alias is left to resolveAlias as is.
+func (m *Manager) validMirrorID(c *gin.Context) {
+	id := c.Param("id")
+	normalized := internal.NormalizeMirrorID(id)
+	err := internal.ValidateMirrorID(normalized)
+	if err == nil && normalized == id {
+		c.Next()
+		return
+	}
+
+	jobs := new(v1beta1.JobList)
+	if lerr := m.client.List(c.Request.Context(), jobs); lerr == nil {
+		for _, v := range jobs.Items {
+			if v.Spec.Config.Alias == id {
+				c.Next()
+				return
+			}
+		}
+	}
+	if err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		c.Abort()
+		return
+	}
+	setParam(c, "id", normalized)
+	c.Next()
+}
+
+// validateAlias checks the alias of a job is used by no other job, as
+// either a name or an alias
+func (m *Manager) validateAlias(ctx context.Context, name, alias string) field.ErrorList {
+	if alias == "" || alias == name {
+		return nil
+	}
+	path := field.NewPath("config", "alias")
+
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(ctx, jobs); err != nil {
+		return field.ErrorList{field.InternalError(path, fmt.Errorf("failed to list mirrors: %w", err))}
+	}
+	for _, v := range jobs.Items {
+		if v.Name == name {
+			continue
+		}
+		if v.Name == alias || v.Spec.Config.Alias == alias {
+			return field.ErrorList{field.Duplicate(path, alias)}
+		}
+	}
+	return nil
+}
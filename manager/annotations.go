@@ -0,0 +1,99 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func (m *Manager) getJobAnnotations(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	annotations := job.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	c.JSON(http.StatusOK, annotations)
+}
+
+// updateJobAnnotations merges the annotations in the body into those of
+// the job, e.g. an owner team or ticket link, a null value removes a key
+func (m *Manager) updateJobAnnotations(c *gin.Context) {
+	mirrorID := c.Param("id")
+	var changes map[string]*string
+	if !m.bindJSON(c, &changes) {
+		return
+	}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+
+	if !m.ifMatch(c, mirrorID, job) {
+		return
+	}
+
+	patch := mergeFrom(c, job.DeepCopy())
+	annotations := make(map[string]string, len(job.Annotations)+len(changes))
+	for k, v := range job.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(annotations, k)
+		} else {
+			annotations[k] = *v
+		}
+	}
+
+	path := field.NewPath("metadata", "annotations")
+	errs := apivalidation.ValidateAnnotations(annotations, path)
+	if len(errs) > 0 {
+		err := fmt.Errorf("invalid annotations of job %s: %s", mirrorID, errs.ToAggregate().Error())
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{_errorKey: err.Error(), _codeKey: internal.ErrInvalid, "fields": toFieldErrors(errs)})
+		return
+	}
+
+	job.Annotations = annotations
+	if err = m.client.Patch(c.Request.Context(), job, patch); err != nil {
+		err := fmt.Errorf("failed to annotate job %s: %w",
+			mirrorID, err,
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	runLog.Info(fmt.Sprintf("Annotations of mirror <%s> updated by %s", mirrorID, c.GetString(identityKey)))
+	c.JSON(http.StatusOK, annotations)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AuditStdout as Options.AuditLog writes the audit trail to stdout
+const AuditStdout = "stdout"
+
+// auditChange is a status change of a mirror made by a request
+type auditChange struct {
+	Mirror string             `json:"mirror"`
+	Before v1beta1.SyncStatus `json:"before"`
+	After  v1beta1.SyncStatus `json:"after"`
+}
+
+// auditRecord is a line of the audit trail, one per mutating request
+type auditRecord struct {
+	Time     time.Time     `json:"time"`
+	Identity string        `json:"identity"`
+	Method   string        `json:"method"`
+	Route    string        `json:"route"`
+	Mirror   string        `json:"mirror,omitempty"`
+	Code     int           `json:"code"`
+	Changes  []auditChange `json:"changes"`
+
+	mu sync.Mutex
+}
+
+func (r *auditRecord) add(change auditChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Changes = append(r.Changes, change)
+}
+
+type auditContextKey struct{}
+
+// auditLogger writes the audit trail as json lines, apart from runLog
+type auditLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// newAuditLogger appends to the file at sink, or writes to stdout
+func newAuditLogger(sink string) (*auditLogger, error) {
+	var w io.Writer = os.Stdout
+	if sink != AuditStdout {
+		f, err := os.OpenFile(sink, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		w = f
+	}
+	return &auditLogger{enc: json.NewEncoder(w)}, nil
+}
+
+func (al *auditLogger) write(r *auditRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Changes == nil {
+		r.Changes = []auditChange{}
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if err := al.enc.Encode(r); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to write audit log: %s", err.Error()))
+	}
+}
+
+// audit records every mutating request along with the status changes
+// made by it, which are collected by auditClient
+func (m *Manager) audit(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		c.Next()
+		return
+	}
+
+	r := &auditRecord{
+		Time:   time.Now(),
+		Method: c.Request.Method,
+		Route:  c.FullPath(),
+	}
+	if strings.Contains(r.Route, "/job/:id") {
+		r.Mirror = c.Param("id")
+	}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), auditContextKey{}, r))
+	c.Next()
+
+	// set by the auth middlewares of the route
+	r.Identity = c.GetString(identityKey)
+	if r.Identity == "" {
+		r.Identity = c.ClientIP()
+	}
+	r.Code = c.Writer.Status()
+	m.auditLog.write(r)
+}
+
+// auditClient adds the status changes of jobs to the audit record of
+// the request
+type auditClient struct {
+	Store
+}
+
+// before gets the status of obj as cached, if it's a job written by
+// an audited request
+func (a auditClient) before(ctx context.Context, obj client.Object) (*auditRecord, v1beta1.SyncStatus, bool) {
+	r, ok := ctx.Value(auditContextKey{}).(*auditRecord)
+	if !ok {
+		return nil, "", false
+	}
+	if _, ok = obj.(*v1beta1.Job); !ok {
+		return nil, "", false
+	}
+	job := new(v1beta1.Job)
+	if err := a.Store.Get(ctx, client.ObjectKeyFromObject(obj), job); err != nil {
+		return r, "", true
+	}
+	return r, job.Status.Status, true
+}
+
+func (a auditClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	r, before, ok := a.before(ctx, obj)
+	err := a.Store.Delete(ctx, obj, opts...)
+	if ok && err == nil {
+		r.add(auditChange{Mirror: obj.GetName(), Before: before})
+	}
+	return err
+}
+
+func (a auditClient) Status() client.SubResourceWriter {
+	return auditStatusWriter{a.Store.Status(), a}
+}
+
+type auditStatusWriter struct {
+	client.SubResourceWriter
+	parent auditClient
+}
+
+func (a auditStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	r, before, ok := a.parent.before(ctx, obj)
+	err := a.SubResourceWriter.Update(ctx, obj, opts...)
+	if ok && err == nil {
+		r.add(auditChange{Mirror: obj.GetName(), Before: before, After: obj.(*v1beta1.Job).Status.Status})
+	}
+	return err
+}
+
+func (a auditStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	r, before, ok := a.parent.before(ctx, obj)
+	err := a.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+	if ok && err == nil {
+		r.add(auditChange{Mirror: obj.GetName(), Before: before, After: obj.(*v1beta1.Job).Status.Status})
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// identityKey is the context key of the authenticated caller
+	identityKey = "identity"
+	adminID     = "admin"
+	workerID    = "worker"
+)
+
+// workerTokenBytes is the entropy of a worker token
+const workerTokenBytes = 32
+
+func bearerToken(c *gin.Context) string {
+	return strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// requireAdmin guards the admin routes with Options.AdminToken, the
+// routes are open like the rest of the api when no token is set
+func (m *Manager) requireAdmin(c *gin.Context) {
+	if m.options().AdminToken == "" {
+		c.Set(identityKey, c.ClientIP())
+		c.Next()
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(bearerToken(c)), []byte(m.options().AdminToken)) != 1 {
+		err := errors.New("admin token required")
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusUnauthorized, err)
+		c.Abort()
+		return
+	}
+	c.Set(identityKey, adminID)
+	c.Next()
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// rejectTokenHash responds 400 to a spec posted with the token hash of
+// the worker, which is issued by rotateToken only
+func (m *Manager) rejectTokenHash(c *gin.Context, jobSpec map[string]map[string]interface{}) bool {
+	if _, ok := jobSpec["config"]["tokenHash"]; !ok {
+		return true
+	}
+	err := errors.New("tokenHash is issued by /job/:id/token only")
+	c.Error(err)
+	m.returnErrJSON(c, http.StatusBadRequest, err)
+	return false
+}
+
+// requireWorker guards the routes a worker reports to, the bearer token
+// must match the token of the mirror in the path, or be the admin token
+func (m *Manager) requireWorker(c *gin.Context) {
+	mirrorID := c.Param("id")
+	token := bearerToken(c)
+
+	if m.options().AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.options().AdminToken)) == 1 {
+		c.Set(identityKey, adminID)
+		c.Next()
+		return
+	}
+
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		c.Abort()
+		return
+	}
+	if job.Status.TokenHash == "" {
+		c.Set(identityKey, c.ClientIP())
+		c.Next()
+		m.reported(c, mirrorID)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(job.Status.TokenHash)) != 1 {
+		err := fmt.Errorf("token of mirror %s required", mirrorID)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusUnauthorized, err)
+		c.Abort()
+		return
+	}
+	c.Set(identityKey, workerID+"/"+mirrorID)
+	c.Next()
+	m.reported(c, mirrorID)
+}
+
+// reported counts a successful report of the worker as a heartbeat
+func (m *Manager) reported(c *gin.Context, mirrorID string) {
+	if c.Writer.Status() < http.StatusBadRequest {
+		m.heartbeatSeen(c.Request.Context(), mirrorID)
+	}
+}
+
+// rotateToken issues a new worker token of the mirror, the old one stops
+// working at once
+func (m *Manager) rotateToken(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	b := make([]byte, workerTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	// kept in the status, which only the manager writes, the spec is
+	// open to anyone posting to /job/:id
+	err := m.updateJobStatus(c.Request.Context(), mirrorID, func(job *v1beta1.Job) (bool, error) {
+		job.Status.TokenHash = hashToken(token)
+		return true, nil
+	})
+	if err != nil {
+		err := fmt.Errorf("failed to rotate token of job %s: %w",
+			mirrorID, err,
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	runLog.Info(fmt.Sprintf("Token of mirror <%s> rotated by %s", mirrorID, c.GetString(identityKey)))
+	c.JSON(http.StatusOK, internal.MirrorToken{Token: token})
+}
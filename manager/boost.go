@@ -0,0 +1,148 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// how often expired boosts are looked for
+	boostCheckInterval = time.Minute
+	maxBoostDuration   = 7 * 24 * time.Hour
+)
+
+var errNotBoosted = errors.New("mirror not boosted")
+
+// boostJob sets a shorter interval for a while, boosting a boosted mirror
+// extends it. The boost is kept in the status, which the worker reads as
+// it schedules its next sync, as a change of the spec restarts the
+// worker and the sync it runs.
+func (m *Manager) boostJob(c *gin.Context) {
+	mirrorID := c.Param("id")
+	var boost internal.MirrorBoost
+	if !m.bindJSON(c, &boost) {
+		return
+	}
+	if boost.Interval < minInterval || boost.Interval > maxInterval {
+		err := fmt.Errorf("interval %d must be between %d and %d minutes", boost.Interval, minInterval, maxInterval)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	d, err := time.ParseDuration(boost.Duration)
+	if err != nil || d <= 0 || d > maxBoostDuration {
+		err := fmt.Errorf("invalid duration %s, must be positive and at most %s", boost.Duration, maxBoostDuration)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	if !m.ifMatch(c, mirrorID, job) {
+		return
+	}
+
+	job.Status.BoostUntil = m.now().Add(d).Unix()
+	job.Status.BoostInterval = boost.Interval
+	if err = m.client.Status().Update(c.Request.Context(), job); err != nil {
+		err := fmt.Errorf("failed to boost job %s: %w", mirrorID, err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	runLog.Info(fmt.Sprintf("Mirror <%s> boosted to every %d minutes for %s by %s", mirrorID, boost.Interval, d, c.GetString(identityKey)))
+	boost.Until = job.Status.BoostUntil
+	c.JSON(http.StatusOK, boost)
+}
+
+// unboostJob ends a boost early
+func (m *Manager) unboostJob(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	if !m.ifMatch(c, mirrorID, job) {
+		return
+	}
+	if job.Status.BoostUntil == 0 {
+		c.Error(errNotBoosted)
+		m.returnErrJSON(c, http.StatusConflict, errNotBoosted)
+		return
+	}
+	if err = m.endBoost(c.Request.Context(), job); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{_infoKey: "unboosted"})
+}
+
+// endBoost clears the boost of the job, the caller holds m.rwmu
+func (m *Manager) endBoost(ctx context.Context, job *v1beta1.Job) error {
+	job.Status.BoostUntil = 0
+	job.Status.BoostInterval = 0
+	if err := m.client.Status().Update(ctx, job); err != nil {
+		return fmt.Errorf("failed to unboost job %s: %w", job.Name, err)
+	}
+	runLog.Info(fmt.Sprintf("Mirror <%s> unboosted", job.Name))
+	return nil
+}
+
+// watchBoosts ends the expired boosts every boostCheckInterval until ctx
+// is done
+func (m *Manager) watchBoosts(ctx context.Context) {
+	m.runEvery(ctx, boostCheckInterval, func() { m.expireBoosts(ctx, m.now()) })
+}
+
+func (m *Manager) expireBoosts(ctx context.Context, now time.Time) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(ctx, jobs); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to list jobs for boosts: %s", err.Error()))
+		return
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Status.BoostUntil == 0 || job.Status.BoostUntil > now.Unix() {
+			continue
+		}
+		if err := m.endBoost(ctx, job); err != nil {
+			runLog.Error(err, err.Error())
+		}
+	}
+}
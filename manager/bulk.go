@@ -0,0 +1,283 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// handleBulkCmd sends a start or restart command to every mirror whose
+// type or provider is ?type, e.g. ?type=rsync, or to the mirror ?id only
+// with ?assign=true, see assignCmd
+func (m *Manager) handleBulkCmd(c *gin.Context) {
+	if assign, _ := strconv.ParseBool(c.Query("assign")); assign {
+		m.assignCmd(c)
+		return
+	}
+	mirrorType := c.Query("type")
+	if mirrorType == "" {
+		err := errors.New("type is required")
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	var clientCmd internal.ClientCmd
+	if !m.bindJSON(c, &clientCmd) {
+		return
+	}
+	switch clientCmd.Cmd {
+	case internal.CmdStart, internal.CmdRestart:
+	default:
+		err := fmt.Errorf("command '%s' can't be sent to all mirrors", clientCmd.Cmd)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	var ids []string
+	result := internal.BulkCmdResult{Sent: []string{}, Failed: map[string]string{}}
+
+	m.rwmu.Lock()
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(ctx, jobs); err != nil {
+		m.rwmu.Unlock()
+		err := fmt.Errorf("failed to list mirrors: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	for _, v := range jobs.Items {
+		if v.Spec.Config.Type == v1beta1.External {
+			continue
+		}
+		if string(v.Spec.Config.Type) != mirrorType && v.Spec.Config.Provider != mirrorType {
+			continue
+		}
+		if err := m.checkDependencies(ctx, &v); err != nil {
+			result.Failed[v.Name] = err.Error()
+			continue
+		}
+		// a started job gets a fresh failure count, as with a single start
+		if clientCmd.Cmd == internal.CmdStart && (v.Status.ConsecutiveFailures != 0 || v.Status.NextRetry != 0) {
+			v.Status.ConsecutiveFailures = 0
+			v.Status.NextRetry = 0
+			if err := m.client.Status().Update(ctx, &v); err != nil {
+				result.Failed[v.Name] = err.Error()
+				continue
+			}
+		}
+		ids = append(ids, v.Name)
+	}
+	m.rwmu.Unlock()
+
+	limit := m.options().CmdConcurrency
+	if limit <= 0 {
+		limit = len(ids)
+	}
+	sem := make(chan struct{}, limit)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_, err := m.sendCmd(ctx, id, clientCmd)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed[id] = err.Error()
+			} else {
+				result.Sent = append(result.Sent, id)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	sort.Strings(result.Sent)
+	runLog.Info(fmt.Sprintf("Sent command '%s' to %d mirrors of %s, %d failed",
+		clientCmd.Cmd, len(result.Sent), mirrorType, len(result.Failed)))
+	c.JSON(http.StatusOK, result)
+}
+
+// syncing tells a mirror whose worker is running a sync
+func syncing(job *v1beta1.Job) bool {
+	return job.Status.Status == v1beta1.PreSyncing || job.Status.Status == v1beta1.Syncing
+}
+
+// assignCmd pins the mirror ?id to the node running the fewest syncs
+// before sending it a start or restart command, as a worker runs a single
+// mirror the node its pod is pinned to is what's loaded. The nodes are
+// those mirrors are pinned to, but draining ones. It responds with the
+// node chosen.
+func (m *Manager) assignCmd(c *gin.Context) {
+	mirrorID := c.Query("id")
+	if mirrorID == "" {
+		err := errors.New("id is required to assign")
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	var clientCmd internal.ClientCmd
+	if !m.bindJSON(c, &clientCmd) {
+		return
+	}
+	switch clientCmd.Cmd {
+	case internal.CmdStart, internal.CmdRestart:
+	default:
+		err := fmt.Errorf("command '%s' can't be assigned", clientCmd.Cmd)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	m.rwmu.Lock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		m.rwmu.Unlock()
+		return
+	}
+	if err = m.checkDependencies(ctx, job); err != nil {
+		m.rwmu.Unlock()
+		m.returnCmdErr(c, err)
+		return
+	}
+	jobs := new(v1beta1.JobList)
+	if err = m.client.List(ctx, jobs); err != nil {
+		m.rwmu.Unlock()
+		err := fmt.Errorf("failed to list mirrors: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	load := m.nodeLoad(jobs.Items, syncing)
+	assignment := internal.CmdAssignment{ID: mirrorID, Node: leastLoaded(load), From: job.Spec.Deploy.NodeName}
+	if assignment.Node == "" {
+		m.rwmu.Unlock()
+		err := errors.New("no node to assign, no mirror is pinned to one that isn't draining")
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusConflict, err)
+		return
+	}
+	assignment.Load = load[assignment.Node]
+	if assignment.Node != assignment.From {
+		patch := client.MergeFrom(job.DeepCopy())
+		job.Spec.Deploy.NodeName = assignment.Node
+		if err = m.client.Patch(ctx, job, patch); err != nil {
+			m.rwmu.Unlock()
+			err := fmt.Errorf("failed to assign job %s to node %s: %w", mirrorID, assignment.Node, err)
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusInternalServerError, err)
+			return
+		}
+		runLog.Info(fmt.Sprintf("Mirror <%s> assigned to node %s running %d syncs by %s",
+			mirrorID, assignment.Node, assignment.Load, c.GetString(identityKey)))
+	}
+	m.rwmu.Unlock()
+
+	// the worker rolled out on the node takes the command once up, the
+	// post is retried meanwhile
+	if code, err := m.sendCmd(ctx, mirrorID, clientCmd); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, code, err)
+		return
+	}
+	c.JSON(http.StatusOK, assignment)
+}
+
+// deleteJobs deletes the mirrors in ?ids=a,b,c or matching ?labelSelector,
+// ?confirm=true is required as a guard against mass deletion by mistake
+func (m *Manager) deleteJobs(c *gin.Context) {
+	if confirm, _ := strconv.ParseBool(c.Query("confirm")); !confirm {
+		err := errors.New("confirm=true is required to delete mirrors in bulk")
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	ids := c.Query("ids")
+	if (ids == "") == (c.Query("labelSelector") == "") {
+		err := errors.New("exactly one of ids and labelSelector is required")
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	opts, ok := m.listOptions(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	result := internal.BulkDeleteResult{Deleted: []string{}, Failed: map[string]string{}}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+
+	var targets []v1beta1.Job
+	if ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				continue
+			}
+			job := new(v1beta1.Job)
+			if err := m.client.Get(ctx, client.ObjectKey{Name: id}, job); err != nil {
+				result.Failed[id] = err.Error()
+				continue
+			}
+			targets = append(targets, *job)
+		}
+	} else {
+		jobs := new(v1beta1.JobList)
+		if err := m.client.List(ctx, jobs, opts...); err != nil {
+			err := fmt.Errorf("failed to list mirrors: %w", err)
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusInternalServerError, err)
+			return
+		}
+		targets = jobs.Items
+	}
+
+	for i := range targets {
+		if err := m.client.Delete(ctx, &targets[i]); err != nil {
+			result.Failed[targets[i].Name] = err.Error()
+			continue
+		}
+		result.Deleted = append(result.Deleted, targets[i].Name)
+	}
+
+	sort.Strings(result.Deleted)
+	runLog.Info(fmt.Sprintf("Deleted %d mirrors in bulk, %d failed", len(result.Deleted), len(result.Failed)))
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// cacheState tracks the sync of the job informer, as a stuck informer
+// leaves every request hanging
+type cacheState struct {
+	informer atomic.Pointer[cache.Informer]
+
+	started    atomic.Int64
+	syncedAt   atomic.Int64
+	lastResync atomic.Int64
+
+	lastResyncGauge prometheus.Gauge
+}
+
+func newCacheState(registry *prometheus.Registry) *cacheState {
+	cs := &cacheState{
+		lastResyncGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kubesync_cache_last_resync_timestamp_seconds",
+			Help: "Time of the last resync of the job informer",
+		}),
+	}
+	registry.MustRegister(cs.lastResyncGauge, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "kubesync_cache_synced",
+		Help: "Whether the job informer has synced",
+	}, func() float64 {
+		if cs.hasSynced() {
+			return 1
+		}
+		return 0
+	}))
+	return cs
+}
+
+func (cs *cacheState) hasSynced() bool {
+	informer := cs.informer.Load()
+	return informer != nil && (*informer).HasSynced()
+}
+
+// handler records the resyncs, which are updates keeping the resource version
+func (cs *cacheState) handler() toolscache.ResourceEventHandlerFuncs {
+	return toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			o, ok := eventJob(oldObj)
+			n, nok := eventJob(newObj)
+			if ok && nok && o.ResourceVersion == n.ResourceVersion {
+				now := time.Now()
+				cs.lastResync.Store(now.Unix())
+				cs.lastResyncGauge.Set(float64(now.Unix()))
+			}
+		},
+	}
+}
+
+func (cs *cacheState) status() internal.CacheStatus {
+	return internal.CacheStatus{
+		Synced:     cs.hasSynced(),
+		Started:    cs.started.Load(),
+		SyncedAt:   cs.syncedAt.Load(),
+		LastResync: cs.lastResync.Load(),
+	}
+}
+
+func (m *Manager) getCacheStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, m.cacheState.status())
+}
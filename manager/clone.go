@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// cloneJob creates a new mirror with the spec and labels of the mirror,
+// the status starts over. The alias and token are not copied as they
+// belong to a single mirror.
+func (m *Manager) cloneJob(c *gin.Context) {
+	mirrorID := c.Param("id")
+	var clone internal.MirrorClone
+	if !m.bindJSON(c, &clone) {
+		return
+	}
+	if clone.ID == "" {
+		err := errors.New("id of the clone required")
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	clone.ID = internal.NormalizeMirrorID(clone.ID)
+	if err := internal.ValidateMirrorID(clone.ID); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	src, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+
+	job := v1beta1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: v1beta1.GroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   clone.ID,
+			Labels: src.Labels,
+		},
+		Spec: *src.Spec.DeepCopy(),
+	}
+	job.Spec.Config.Alias = ""
+
+	errs := validateJobSpec(&job.Spec)
+	errs = append(errs, m.validateDependsOn(c.Request.Context(), clone.ID, job.Spec.Config.DependsOn, nil)...)
+	errs = append(errs, m.validateNodeName(&job.Spec, "")...)
+	if len(errs) > 0 {
+		err := fmt.Errorf("invalid job %s: %s", clone.ID, errs.ToAggregate().Error())
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{_errorKey: err.Error(), _codeKey: internal.ErrInvalid, "fields": toFieldErrors(errs)})
+		return
+	}
+
+	// unlike createJob, an existing mirror is never overwritten
+	if err = m.ensureNamespace(c.Request.Context()); err == nil {
+		err = m.client.Create(c.Request.Context(), &job, client.FieldOwner("mirror-controller"))
+	}
+	if err != nil {
+		err := fmt.Errorf("failed to clone job %s to %s: %w",
+			mirrorID, clone.ID, m.namespaceError(err),
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	runLog.Info(fmt.Sprintf("Mirror <%s> cloned to <%s> by %s", mirrorID, clone.ID, c.GetString(identityKey)))
+	c.JSON(http.StatusOK, gin.H{_infoKey: "clone " + clone.ID + " succeed"})
+}
@@ -18,10 +18,10 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
-	"encoding/json"
 	"flag"
-	"github.com/CQUPTMirror/kubesync/manager/mirrorz"
 	"os"
+	// the distroless image ships no zoneinfo, used by ?tz of schedules
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -30,6 +30,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -51,32 +52,40 @@ func init() {
 }
 
 func main() {
-	var apiAddr string
-	addrEnv := os.Getenv("ADDR")
-	if addrEnv == "" {
-		addrEnv = ":3000"
-	}
-	flag.StringVar(&apiAddr, "addr", addrEnv, "The port the api endpoint binds to.")
+	var apiAddr, configFile string
+	flag.StringVar(&apiAddr, "addr", "", "The port the api endpoint binds to, overrides the config.")
+	flag.StringVar(&configFile, "config", os.Getenv("CONFIG"), "The yaml or json config file, environment variables override it.")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	var mirrorZ *mirrorz.MirrorZ = nil
-	var mirrorInfo mirrorz.MirrorZ
-	if err := json.Unmarshal([]byte(os.Getenv("MIRRORZ")), &mirrorInfo); err == nil {
-		mirrorZ = &mirrorInfo
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	var options manager.Options
+	var err error
+	if configFile != "" {
+		options, err = manager.LoadOptionsFromFile(configFile)
+	} else {
+		options, err = manager.LoadOptionsFromEnv()
+	}
+	if err != nil {
+		setupLog.Error(err, "unable to load config")
+		os.Exit(1)
+	}
+	options.Scheme = scheme
+	if apiAddr != "" {
+		options.Address = apiAddr
 	}
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	// jobs in memory need no api server
+	var config *rest.Config
+	if options.Storage != manager.StorageMemory {
+		config = ctrl.GetConfigOrDie()
+	}
 
-	mgr, err := manager.GetTUNASyncManager(ctrl.GetConfigOrDie(), manager.Options{
-		Scheme:  scheme,
-		Address: apiAddr,
-		MirrorZ: mirrorZ,
-		Total:   os.Getenv("TOTAL"),
-	})
+	mgr, err := manager.GetTUNASyncManager(config, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start api service")
 		os.Exit(1)
@@ -0,0 +1,112 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/CQUPTMirror/kubesync/manager/tunasync"
+	"github.com/gin-gonic/gin"
+)
+
+// tunasyncStatuses maps the statuses tunasync lacks onto the closest it has
+var tunasyncStatuses = map[v1beta1.SyncStatus]v1beta1.SyncStatus{
+	"":              v1beta1.None,
+	v1beta1.Cached:  v1beta1.Success,
+	v1beta1.Created: v1beta1.None,
+	v1beta1.Offline: v1beta1.Paused,
+	v1beta1.Unknown: v1beta1.None,
+}
+
+// tunasyncTime is unix seconds as tunasync keeps it, where never is the
+// zero time
+func tunasyncTime(sec int64, loc *time.Location) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).In(loc)
+}
+
+// toTunasync renders ws the way the manager of tunasync lists mirrors,
+// the times are in ?tz, or in local time like tunasync
+func toTunasync(ws []internal.MirrorStatus, loc *time.Location) []tunasync.WebMirrorStatus {
+	if loc == nil {
+		loc = time.Local
+	}
+	statuses := make([]tunasync.WebMirrorStatus, 0, len(ws))
+	for _, w := range ws {
+		status := w.Status
+		if s, ok := tunasyncStatuses[status]; ok {
+			status = s
+		}
+		lastUpdate := tunasyncTime(w.LastUpdate, loc)
+		lastStarted := tunasyncTime(w.LastStarted, loc)
+		lastEnded := tunasyncTime(w.LastEnded, loc)
+		scheduled := tunasyncTime(w.Scheduled, loc)
+		size := w.SizeStr
+		if w.Size == 0 {
+			// tunasync reports unknown sizes as such
+			size = "unknown"
+		}
+		upstream := w.Upstream
+		if upstream == "" {
+			upstream = w.UpstreamURL
+		}
+		statuses = append(statuses, tunasync.WebMirrorStatus{
+			Name:          w.ID,
+			IsMaster:      true,
+			Status:        string(status),
+			LastUpdate:    tunasync.TextTime{Time: lastUpdate},
+			LastUpdateTs:  tunasync.StampTime{Time: lastUpdate},
+			LastStarted:   tunasync.TextTime{Time: lastStarted},
+			LastStartedTs: tunasync.StampTime{Time: lastStarted},
+			LastEnded:     tunasync.TextTime{Time: lastEnded},
+			LastEndedTs:   tunasync.StampTime{Time: lastEnded},
+			Scheduled:     tunasync.TextTime{Time: scheduled},
+			ScheduledTs:   tunasync.StampTime{Time: scheduled},
+			Upstream:      upstream,
+			Size:          size,
+		})
+	}
+	return statuses
+}
+
+// respondCompat responds with ws in the schema of ?compat, it returns
+// false when no compat is asked for
+func (m *Manager) respondCompat(c *gin.Context, ws []internal.MirrorStatus) bool {
+	compat, ok := c.GetQuery("compat")
+	if !ok {
+		return false
+	}
+	switch compat {
+	case "tunasync":
+		loc, ok := m.scheduleLocation(c)
+		if ok {
+			c.JSON(http.StatusOK, toTunasync(ws, loc))
+		}
+	default:
+		err := fmt.Errorf("invalid compat %s, must be tunasync", compat)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+	}
+	return true
+}
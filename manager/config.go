@@ -0,0 +1,200 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/manager/mirrorz"
+	"sigs.k8s.io/yaml"
+)
+
+// LoadOptionsFromFile reads Options from a yaml or json file, unknown
+// keys are rejected. Environment variables override the file.
+func LoadOptionsFromFile(path string) (Options, error) {
+	var options Options
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return options, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+	if err = yaml.UnmarshalStrict(data, &options); err != nil {
+		return options, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return options, applyEnvOptions(&options)
+}
+
+// LoadOptionsFromEnv reads Options from environment variables only
+func LoadOptionsFromEnv() (Options, error) {
+	var options Options
+	return options, applyEnvOptions(&options)
+}
+
+// applyEnvOptions overrides options with the environment variables set
+func applyEnvOptions(o *Options) error {
+	strs := map[string]*string{
+		"ADDR":             &o.Address,
+		"TOTAL":            &o.Total,
+		"NAMESPACE":        &o.Namespace,
+		"BASE_PATH":        &o.BasePath,
+		"LOG_LEVEL":        &o.LogLevel,
+		"LOG_FORMAT":       &o.LogFormat,
+		"ADMIN_TOKEN":      &o.AdminToken,
+		"TLS_CERT_FILE":    &o.TLSCertFile,
+		"TLS_KEY_FILE":     &o.TLSKeyFile,
+		"TRACING_ENDPOINT": &o.TracingEndpoint,
+		"AUDIT_LOG":        &o.AuditLog,
+		"STORAGE":          &o.Storage,
+		"CMD_SECRET":       &o.CmdSecret,
+		"STATE_CONFIGMAP":  &o.StateConfigMap,
+		"UNKNOWN_STATUS":   &o.UnknownStatus,
+		"STATUS_CONFLICT":  &o.StatusConflict,
+		"RELOAD_CONFIGMAP": &o.ReloadConfigMap,
+	}
+	for k, p := range strs {
+		if v := os.Getenv(k); v != "" {
+			*p = v
+		}
+	}
+
+	ints := map[string]*int{
+		"HISTORY_LIMIT":           &o.HistoryLimit,
+		"AUTO_PAUSE_FAILURES":     &o.AutoPauseFailures,
+		"CMD_CONCURRENCY":         &o.CmdConcurrency,
+		"CMD_RETRIES":             &o.CmdRetries,
+		"OFFLINE_AFTER_MISSES":    &o.OfflineAfterMisses,
+		"MAX_CONCURRENT_REQUESTS": &o.MaxConcurrentRequests,
+		"DEFAULT_INTERVAL":        &o.DefaultInterval,
+	}
+	for k, p := range ints {
+		if v := os.Getenv(k); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", k, err)
+			}
+			*p = i
+		}
+	}
+
+	// comma separated, e.g. 10.0.0.0/8,192.168.1.1
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		o.TrustedProxies = strings.Split(v, ",")
+		for i := range o.TrustedProxies {
+			o.TrustedProxies[i] = strings.TrimSpace(o.TrustedProxies[i])
+		}
+	}
+
+	if v := os.Getenv("LOG_BODIES"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid LOG_BODIES: %w", err)
+		}
+		o.LogBodies = b
+	}
+
+	if v := os.Getenv("DEBUG_PANICS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid DEBUG_PANICS: %w", err)
+		}
+		o.DebugPanics = b
+	}
+
+	if v := os.Getenv("LOOP_JITTER"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid LOOP_JITTER: %w", err)
+		}
+		o.LoopJitter = f
+	}
+
+	if v := os.Getenv("READ_ONLY"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid READ_ONLY: %w", err)
+		}
+		o.ReadOnly = b
+	}
+
+	if v := os.Getenv("ENSURE_NAMESPACE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid ENSURE_NAMESPACE: %w", err)
+		}
+		o.EnsureNamespace = b
+	}
+
+	if v := os.Getenv("SIGNED_CMDS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid SIGNED_CMDS: %w", err)
+		}
+		o.SignedCmds = b
+	}
+
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid MAX_BODY_BYTES: %w", err)
+		}
+		o.MaxBodyBytes = i
+	}
+
+	durations := map[string]*time.Duration{
+		"READ_TIMEOUT":       &o.ReadTimeout.Duration,
+		"WRITE_TIMEOUT":      &o.WriteTimeout.Duration,
+		"CMD_TIMEOUT":        &o.CmdTimeout.Duration,
+		"HEARTBEAT_INTERVAL": &o.HeartbeatInterval.Duration,
+	}
+	for k, p := range durations {
+		if v := os.Getenv(k); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", k, err)
+			}
+			*p = d
+		}
+	}
+
+	if v := os.Getenv("MIRRORZ"); v != "" {
+		var mirrorInfo mirrorz.MirrorZ
+		if err := json.Unmarshal([]byte(v), &mirrorInfo); err != nil {
+			return fmt.Errorf("invalid MIRRORZ: %w", err)
+		}
+		o.MirrorZ = &mirrorInfo
+	}
+
+	// a json object of specs by type, e.g. {"mirror":{"config":{"interval":720}}}
+	if v := os.Getenv("DEFAULTS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &o.Defaults); err != nil {
+			return fmt.Errorf("invalid DEFAULTS: %w", err)
+		}
+	}
+
+	// a json object, e.g. {"X-Frame-Options":"SAMEORIGIN"}
+	if v := os.Getenv("RESPONSE_HEADERS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &o.ResponseHeaders); err != nil {
+			return fmt.Errorf("invalid RESPONSE_HEADERS: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+const redacted = "<redacted>"
+
+// debugState is a snapshot of the runtime state of the manager
+type debugState struct {
+	Time        time.Time                 `json:"time"`
+	Version     string                    `json:"version"`
+	Goroutines  int                       `json:"goroutines"`
+	Cache       internal.CacheStatus      `json:"cache"`
+	Jobs        int                       `json:"jobs"`
+	JobsError   string                    `json:"jobsError,omitempty"`
+	Heartbeats  map[string]heartbeatState `json:"heartbeats"`
+	Idempotency []idempotencyKey          `json:"idempotency"`
+	Maintenance internal.Maintenance      `json:"maintenance"`
+	Scheduling  internal.Scheduling       `json:"scheduling"`
+	Options     Options                   `json:"options"`
+}
+
+// redactedOptions returns the options without secrets
+func (m *Manager) redactedOptions() Options {
+	o := *m.options()
+	if o.AdminToken != "" {
+		o.AdminToken = redacted
+	}
+	if o.CmdSecret != "" {
+		o.CmdSecret = redacted
+	}
+	if o.TLSKeyFile != "" {
+		o.TLSKeyFile = redacted
+	}
+	return o
+}
+
+// getDebugState dumps the state for diagnosing a manager which stops
+// updating, e.g. a stuck cache or unreachable workers
+func (m *Manager) getDebugState(c *gin.Context) {
+	state := debugState{
+		Time:        time.Now(),
+		Version:     Version,
+		Goroutines:  runtime.NumGoroutine(),
+		Cache:       m.cacheState.status(),
+		Heartbeats:  m.heartbeats.snapshot(),
+		Idempotency: m.idempotency.keys(),
+		Maintenance: m.maintenance.get(m.now()),
+		Scheduling:  m.scheduling.get(),
+		Options:     m.redactedOptions(),
+	}
+
+	// a stuck cache would block the list, report what's known so far
+	if state.Cache.Synced {
+		jobs := new(v1beta1.JobList)
+		if err := m.client.List(c.Request.Context(), jobs); err != nil {
+			state.JobsError = err.Error()
+		} else {
+			state.Jobs = len(jobs.Items)
+		}
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// debugRecovery turns a panic of a handler into a 500 like gin.Recovery,
+// with the stack in the response and logged at error level, for
+// Options.DebugPanics
+func debugRecovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		stack := string(debug.Stack())
+		err := fmt.Errorf("panic: %v", recovered)
+		runLog.Error(err, fmt.Sprintf("Panic in request %s %s", c.Request.Method, c.Request.URL.Path), "stack", stack)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			_errorKey: err.Error(),
+			_codeKey:  internal.ErrInternal,
+			"stack":   stack,
+		})
+	})
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+var mirrorTypes = []v1beta1.MirrorType{v1beta1.Mirror, v1beta1.Proxy, v1beta1.Git, v1beta1.External}
+
+func isMirrorType(t v1beta1.MirrorType) bool {
+	for _, v := range mirrorTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDefaults checks the types of Options.Defaults, the specs may
+// lack what every mirror sets itself, e.g. the upstream
+func validateDefaults(defaults map[v1beta1.MirrorType]v1beta1.JobSpec) error {
+	for t := range defaults {
+		if !isMirrorType(t) {
+			return fmt.Errorf("invalid defaults of unknown type %s", t)
+		}
+	}
+	return nil
+}
+
+// defaultSpec is the spec a new mirror of the type starts from, a job
+// without type is a mirror
+func (m *Manager) defaultSpec(t v1beta1.MirrorType) v1beta1.JobSpec {
+	if t == "" {
+		t = v1beta1.Mirror
+	}
+	spec := m.options().Defaults[t]
+	return *spec.DeepCopy()
+}
+
+// withDefaults merges the spec of a new mirror, as posted to createJob,
+// over the defaults of its type
+func (m *Manager) withDefaults(c *gin.Context, jobSpec map[string]map[string]interface{}) *v1beta1.JobSpec {
+	var t v1beta1.MirrorType
+	if s, ok := jobSpec["config"]["type"].(string); ok {
+		t = v1beta1.MirrorType(s)
+	}
+	defaults := m.defaultSpec(t)
+
+	b, err := json.Marshal(defaults)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return nil
+	}
+	var spec map[string]map[string]interface{}
+	if err = json.Unmarshal(b, &spec); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return nil
+	}
+	return handleMerge(c, &spec, &jobSpec)
+}
+
+// getDefaults responds with the spec new mirrors of :type inherit, the
+// fields posted to /job/:id on creation override it
+func (m *Manager) getDefaults(c *gin.Context) {
+	t := v1beta1.MirrorType(c.Param("type"))
+	if !isMirrorType(t) {
+		err := fmt.Errorf("unknown type %s", t)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, m.defaultSpec(t))
+}
+
+// getEffectiveConfig responds with the config the worker of the mirror
+// runs with. The defaults of its type are merged into the spec when the
+// mirror is created, what's still unset is filled as the worker does.
+func (m *Manager) getEffectiveConfig(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	config := internal.MirrorConfig{ID: mirrorID, JobSpec: *job.Spec.DeepCopy()}
+	m.withDefaultInterval(&config.Config)
+	withWorkerDefaults(mirrorID, &config.Config)
+	c.JSON(http.StatusOK, config)
+}
+
+// withWorkerDefaults fills the config a worker falls back on when the
+// spec leaves it unset, see worker.LoadConfig
+func withWorkerDefaults(mirrorID string, cfg *v1beta1.JobConfig) {
+	if cfg.Type == v1beta1.Proxy || cfg.Type == v1beta1.External {
+		return
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = internal.DefaultProvider
+	}
+	if cfg.Concurrent == 0 {
+		cfg.Concurrent = internal.DefaultConcurrent
+	}
+	if cfg.Retry == 0 {
+		cfg.Retry = internal.DefaultRetry
+	}
+	if cfg.MirrorPath == "" {
+		cfg.MirrorPath = path.Join(internal.DefaultMirrorDir, mirrorID)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var errDependency = errors.New("dependencies not synced")
+
+// dependencySynced tells whether a job others depend on is ready, jobs
+// which never sync, e.g. proxies, don't hold others back
+func dependencySynced(job *v1beta1.Job) bool {
+	switch job.Spec.Config.Type {
+	case "", v1beta1.Mirror:
+		return job.Status.Status == v1beta1.Success
+	}
+	return true
+}
+
+// checkDependencies returns errDependency unless the last sync of every
+// job in DependsOn succeeded
+func (m *Manager) checkDependencies(ctx context.Context, job *v1beta1.Job) error {
+	var pending []string
+	for _, name := range job.Spec.Config.DependsOn {
+		dep := new(v1beta1.Job)
+		if err := m.client.Get(ctx, client.ObjectKey{Name: name}, dep); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to get dependency %s: %w", name, err)
+			}
+			pending = append(pending, name+" (missing)")
+			continue
+		}
+		if !dependencySynced(dep) {
+			pending = append(pending, fmt.Sprintf("%s (%s)", name, dep.Status.Status))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %s", errDependency, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// validateDependsOn rejects dependencies which don't exist or lead back
+// to the job itself. batch are the jobs created along with it, e.g. by an
+// import, with their dependencies.
+func (m *Manager) validateDependsOn(ctx context.Context, name string, deps []string, batch map[string][]string) field.ErrorList {
+	if len(deps) == 0 {
+		return nil
+	}
+	path := field.NewPath("config", "dependsOn")
+
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(ctx, jobs); err != nil {
+		return field.ErrorList{field.InternalError(path, fmt.Errorf("failed to list mirrors: %w", err))}
+	}
+	graph := make(map[string][]string, len(jobs.Items)+len(batch)+1)
+	for _, v := range jobs.Items {
+		graph[v.Name] = v.Spec.Config.DependsOn
+	}
+	for k, v := range batch {
+		graph[k] = v
+	}
+	graph[name] = deps
+
+	var errs field.ErrorList
+	for i, dep := range deps {
+		if _, ok := graph[dep]; !ok {
+			errs = append(errs, field.NotFound(path.Index(i), dep))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if cycle := findCycle(graph, name); cycle != nil {
+		return field.ErrorList{field.Invalid(path, deps, "dependency cycle "+strings.Join(cycle, " -> "))}
+	}
+	return nil
+}
+
+// findCycle returns a path from start back to start, or nil
+func findCycle(graph map[string][]string, start string) []string {
+	visited := map[string]bool{start: true}
+	path := []string{start}
+
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		for _, dep := range graph[name] {
+			if dep == start {
+				path = append(path, dep)
+				return true
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			path = append(path, dep)
+			if visit(dep) {
+				return true
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+	if visit(start) {
+		return path
+	}
+	return nil
+}
+
+// getJobDependencies responds 409 unless the jobs the mirror depends on
+// synced, workers check it before they start a scheduled sync
+func (m *Manager) getJobDependencies(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	if err = m.checkDependencies(c.Request.Context(), job); err != nil {
+		m.returnCmdErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{_infoKey: "dependencies synced"})
+}
+
+// listDependencies responds with the jobs every mirror depends on
+func (m *Manager) listDependencies(c *gin.Context) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(c.Request.Context(), jobs); err != nil {
+		err := fmt.Errorf("failed to list mirrors: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	graph := make(internal.DependencyGraph, len(jobs.Items))
+	for _, v := range jobs.Items {
+		if v.Spec.Config.Type == v1beta1.External {
+			continue
+		}
+		deps := v.Spec.Config.DependsOn
+		if deps == nil {
+			deps = []string{}
+		}
+		graph[v.Name] = deps
+	}
+	c.JSON(http.StatusOK, graph)
+}
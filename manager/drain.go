@@ -0,0 +1,197 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// drainingNodes are the nodes being decommissioned, which mirrors are no
+// longer pinned to. A worker runs a single mirror, so the node its pod is
+// pinned to by deploy.nodeName is what hosts several of them.
+type drainingNodes struct {
+	mu    sync.RWMutex
+	nodes map[string]int64
+}
+
+func (d *drainingNodes) add(node string, since int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.nodes == nil {
+		d.nodes = make(map[string]int64)
+	}
+	if _, ok := d.nodes[node]; !ok {
+		d.nodes[node] = since
+	}
+}
+
+// remove returns false if the node wasn't draining
+func (d *drainingNodes) remove(node string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.nodes[node]
+	delete(d.nodes, node)
+	return ok
+}
+
+func (d *drainingNodes) has(node string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.nodes[node]
+	return ok
+}
+
+func (d *drainingNodes) snapshot() map[string]int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	nodes := make(map[string]int64, len(d.nodes))
+	for node, since := range d.nodes {
+		nodes[node] = since
+	}
+	return nodes
+}
+
+// validateNodeName rejects pinning a mirror to a draining node, a mirror
+// still pinned to it from before is left alone
+func (m *Manager) validateNodeName(spec *v1beta1.JobSpec, from string) field.ErrorList {
+	node := spec.Deploy.NodeName
+	if node == "" || node == from || !m.draining.has(node) {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(field.NewPath("deploy", "nodeName"), node, "the node is draining")}
+}
+
+// nodeLoad counts the mirrors busy on every node mirrors are pinned to,
+// the draining nodes left out
+func (m *Manager) nodeLoad(jobs []v1beta1.Job, busy func(job *v1beta1.Job) bool) map[string]int {
+	load := make(map[string]int)
+	for i := range jobs {
+		n := jobs[i].Spec.Deploy.NodeName
+		if n == "" || m.draining.has(n) {
+			continue
+		}
+		if _, ok := load[n]; !ok {
+			load[n] = 0
+		}
+		if busy(&jobs[i]) {
+			load[n]++
+		}
+	}
+	return load
+}
+
+// leastLoaded returns the node of load with the least, the first by name
+// on a tie, or empty without any
+func leastLoaded(load map[string]int) string {
+	to := ""
+	for n, l := range load {
+		if to == "" || l < load[to] || (l == load[to] && n < to) {
+			to = n
+		}
+	}
+	return to
+}
+
+// planDrain moves every mirror pinned to node onto the other nodes mirrors
+// are pinned to, the least loaded first. Without any, the mirrors are
+// unpinned and left to the scheduler of kubernetes.
+func (m *Manager) planDrain(node string, jobs []v1beta1.Job) []internal.DrainMove {
+	load := m.nodeLoad(jobs, func(*v1beta1.Job) bool { return true })
+	delete(load, node)
+	var pinned []string
+	for _, v := range jobs {
+		if v.Spec.Deploy.NodeName == node {
+			pinned = append(pinned, v.Name)
+		}
+	}
+	sort.Strings(pinned)
+
+	moves := make([]internal.DrainMove, 0, len(pinned))
+	for _, id := range pinned {
+		to := leastLoaded(load)
+		if to != "" {
+			load[to]++
+		}
+		moves = append(moves, internal.DrainMove{ID: id, From: node, To: to})
+	}
+	return moves
+}
+
+// drainWorker marks the node :id draining and moves the mirrors pinned
+// to it away, the controller then rolls their workers out on the new
+// node. It responds with the moves planned and those failed.
+func (m *Manager) drainWorker(c *gin.Context) {
+	node := c.Param("id")
+	ctx := c.Request.Context()
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	m.draining.add(node, m.now().Unix())
+
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(ctx, jobs); err != nil {
+		err := fmt.Errorf("failed to list mirrors: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	plan := internal.DrainPlan{Worker: node, Moves: m.planDrain(node, jobs.Items), Failed: map[string]string{}}
+	for _, move := range plan.Moves {
+		job := new(v1beta1.Job)
+		if err := m.client.Get(ctx, client.ObjectKey{Name: move.ID}, job); err != nil {
+			plan.Failed[move.ID] = err.Error()
+			continue
+		}
+		patch := client.MergeFrom(job.DeepCopy())
+		job.Spec.Deploy.NodeName = move.To
+		if err := m.client.Patch(ctx, job, patch); err != nil {
+			plan.Failed[move.ID] = err.Error()
+			continue
+		}
+		runLog.Info(fmt.Sprintf("Mirror <%s> moved from node %s to %q", move.ID, node, move.To))
+	}
+	m.saveState(ctx)
+
+	runLog.Info(fmt.Sprintf("Node %s drained of %d mirrors by %s", node, len(plan.Moves)-len(plan.Failed), c.GetString(identityKey)))
+	c.JSON(http.StatusOK, plan)
+}
+
+// undrainWorker lets mirrors be pinned to the node :id again, the mirrors
+// moved away stay where they are
+func (m *Manager) undrainWorker(c *gin.Context) {
+	node := c.Param("id")
+	if !m.draining.remove(node) {
+		err := fmt.Errorf("node %s is not draining", node)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusNotFound, err)
+		return
+	}
+	m.saveState(c.Request.Context())
+
+	runLog.Info(fmt.Sprintf("Node %s undrained by %s", node, c.GetString(identityKey)))
+	c.JSON(http.StatusOK, gin.H{_infoKey: "undrained"})
+}
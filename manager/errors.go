@@ -0,0 +1,111 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const retryAfterHeader = "Retry-After"
+
+// seconds to back off on a conflict, jittered so that clients racing
+// for a job don't collide again
+const (
+	conflictRetryAfter  = 1
+	conflictRetryJitter = 2
+)
+
+// errorStatus maps an error from the api server to the status and code
+// to respond with, the given status is kept for other errors
+func errorStatus(status int, err error) (int, internal.ErrorCode) {
+	switch {
+	case apierrors.IsNotFound(err):
+		return http.StatusNotFound, internal.ErrNotFound
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return http.StatusConflict, internal.ErrConflict
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return http.StatusBadRequest, internal.ErrInvalid
+	case apierrors.IsTooManyRequests(err):
+		return http.StatusTooManyRequests, internal.ErrUnavailable
+	case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		// the manager itself is not allowed, not the client
+		return http.StatusInternalServerError, internal.ErrInternal
+	}
+
+	var transition *transitionError
+	switch {
+	case errors.As(err, &transition):
+		return status, internal.ErrConflict
+	case errors.Is(err, errInMaintenance), errors.Is(err, errSchedulingPaused):
+		return status, internal.ErrUnavailable
+	case errors.Is(err, errDependency):
+		return status, internal.ErrConflict
+	case errors.Is(err, errNamespaceForbidden):
+		return http.StatusForbidden, internal.ErrUnauthorized
+	}
+
+	switch {
+	case status == http.StatusNotFound:
+		return status, internal.ErrNotFound
+	case status == http.StatusConflict, status == http.StatusPreconditionFailed:
+		return status, internal.ErrConflict
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return status, internal.ErrUnauthorized
+	case status == http.StatusServiceUnavailable, status == http.StatusTooManyRequests:
+		return status, internal.ErrUnavailable
+	case status >= http.StatusBadRequest && status < http.StatusInternalServerError:
+		return status, internal.ErrInvalid
+	}
+	return status, internal.ErrInternal
+}
+
+// setRetryAfter tells the client when to retry a request worth retrying
+// as is, a Retry-After already set, e.g. by a rate limiter, is kept
+func setRetryAfter(c *gin.Context, status int, err error) {
+	if c.Writer.Header().Get(retryAfterHeader) != "" {
+		return
+	}
+	switch status {
+	case http.StatusConflict:
+		// illegal transitions and the like fail again whenever retried
+		if apierrors.IsConflict(err) || errors.Is(err, errRequestInProgress) {
+			c.Header(retryAfterHeader, strconv.Itoa(conflictRetryAfter+rand.Intn(conflictRetryJitter+1)))
+		}
+	case http.StatusTooManyRequests:
+		// throttled by the api server, which suggests the delay
+		delay, ok := apierrors.SuggestsClientDelay(err)
+		if !ok || delay <= 0 {
+			delay = conflictRetryAfter
+		}
+		c.Header(retryAfterHeader, strconv.Itoa(delay))
+	}
+}
+
+// noRoute responds 404 in the shape of the other errors, not logged as
+// scanners hit it all the time
+func (m *Manager) noRoute(c *gin.Context) {
+	m.returnErrJSON(c, http.StatusNotFound, fmt.Errorf("no route for %s %s", c.Request.Method, c.Request.URL.Path))
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const eventComponent = "kubesync-manager"
+
+// page size of /job/:id/events
+const (
+	defaultEventLimit = 50
+	maxEventLimit     = 500
+)
+
+// annotations of events routing the alerts on them to the owner
+var (
+	ownerAnnotation   = v1beta1.GroupVersion.Group + "/owner"
+	contactAnnotation = v1beta1.GroupVersion.Group + "/contact"
+)
+
+func eventAnnotations(job *v1beta1.Job) map[string]string {
+	annotations := make(map[string]string)
+	if job.Spec.Config.Owner != "" {
+		annotations[ownerAnnotation] = job.Spec.Config.Owner
+	}
+	if job.Spec.Config.Contact != "" {
+		annotations[contactAnnotation] = job.Spec.Config.Contact
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+// recordEvent creates a kubernetes event on the job, a failure is only logged
+func (m *Manager) recordEvent(ctx context.Context, job *v1beta1.Job, eventType, reason, message string) {
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: job.Name + ".",
+			Annotations:  eventAnnotations(job),
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:            "Job",
+			APIVersion:      v1beta1.GroupVersion.String(),
+			Name:            job.Name,
+			Namespace:       job.Namespace,
+			UID:             job.UID,
+			ResourceVersion: job.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if err := m.client.Create(ctx, event); err != nil {
+		runLog.Error(err, fmt.Sprintf("failed to record event %s of job %s", reason, job.Name))
+	}
+}
+
+// eventTime is when the event last happened, whichever of the fields its
+// reporter set
+func eventTime(e *corev1.Event) metav1.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp
+	case !e.EventTime.IsZero():
+		return metav1.NewTime(e.EventTime.Time)
+	}
+	return e.CreationTimestamp
+}
+
+// getJobEvents responds with the kubernetes events of the job latest
+// first, paged by ?limit and ?offset, so the status page can show what
+// happened to a mirror without access to the cluster. Events are read
+// from the api server as they're not cached, and expire there after an
+// hour by default.
+func (m *Manager) getJobEvents(c *gin.Context) {
+	mirrorID := c.Param("id")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultEventLimit)))
+	if err != nil || limit <= 0 || limit > maxEventLimit {
+		err := fmt.Errorf("invalid limit %s, must be within 1-%d", c.Query("limit"), maxEventLimit)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		err := fmt.Errorf("invalid offset %s", c.Query("offset"))
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	loc, ok := m.timeFormat(c)
+	if !ok {
+		return
+	}
+
+	if _, err = m.GetJob(c, mirrorID); err != nil {
+		return
+	}
+
+	result := internal.MirrorEvents{ID: mirrorID, Events: []internal.MirrorEvent{}}
+	// the memory storage drops events
+	if m.options().Storage == StorageMemory {
+		m.respondTimes(c, result, loc)
+		return
+	}
+
+	// the kind is shared with the batch jobs, e.g. the ones of a cronjob
+	// named after the mirror
+	events := new(corev1.EventList)
+	if err = m.apiReader.List(c.Request.Context(), events, client.MatchingFields{
+		"involvedObject.apiVersion": v1beta1.GroupVersion.String(),
+		"involvedObject.kind":       "Job",
+		"involvedObject.name":       mirrorID,
+	}); err != nil {
+		err := fmt.Errorf("failed to list events of job %s: %w", mirrorID, err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	items := events.Items
+	sort.SliceStable(items, func(i, j int) bool {
+		ti, tj := eventTime(&items[i]), eventTime(&items[j])
+		return tj.Before(&ti)
+	})
+	result.Total = len(items)
+	if offset < len(items) {
+		items = items[offset:]
+	} else {
+		items = nil
+	}
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	for i := range items {
+		e := &items[i]
+		first := e.FirstTimestamp
+		if first.IsZero() {
+			first = eventTime(e)
+		}
+		result.Events = append(result.Events, internal.MirrorEvent{
+			Type:    e.Type,
+			Reason:  e.Reason,
+			Message: e.Message,
+			Count:   e.Count,
+			First:   first.Unix(),
+			Time:    eventTime(e).Unix(),
+		})
+	}
+	m.respondTimes(c, result, loc)
+}
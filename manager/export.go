@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// exportJob responds with the specs of all jobs, which can be fed back
+// to importJob
+func (m *Manager) exportJob(c *gin.Context) {
+	opts, ok := m.listOptions(c)
+	if !ok {
+		return
+	}
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(c.Request.Context(), jobs, opts...); err != nil {
+		err := fmt.Errorf("failed to list mirrors: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	configs := make([]internal.MirrorConfig, 0, len(jobs.Items))
+	for _, v := range jobs.Items {
+		configs = append(configs, internal.MirrorConfig{ID: v.Name, JobSpec: v.Spec})
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].ID < configs[j].ID
+	})
+	c.JSON(http.StatusOK, configs)
+}
+
+// importJob creates or replaces jobs from an export, existing jobs are
+// left untouched with ?overwrite=false
+func (m *Manager) importJob(c *gin.Context) {
+	overwrite := true
+	if v := c.Query("overwrite"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err := fmt.Errorf("invalid overwrite %s: %w", v, err)
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusBadRequest, err)
+			return
+		}
+		overwrite = b
+	}
+
+	var configs []internal.MirrorConfig
+	if !m.bindJSON(c, &configs) {
+		return
+	}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+
+	// the jobs of the import may depend on each other
+	batch := make(map[string][]string, len(configs))
+	for _, conf := range configs {
+		batch[internal.NormalizeMirrorID(conf.ID)] = conf.Config.DependsOn
+	}
+
+	ctx := c.Request.Context()
+	result := internal.MirrorImportResult{
+		Created: []string{},
+		Updated: []string{},
+		Skipped: []string{},
+		Failed:  map[string]string{},
+	}
+	for _, conf := range configs {
+		conf.ID = internal.NormalizeMirrorID(conf.ID)
+		if err := internal.ValidateMirrorID(conf.ID); err != nil {
+			result.Failed[conf.ID] = err.Error()
+			continue
+		}
+		errs := validateJobSpec(&conf.JobSpec)
+		errs = append(errs, m.validateAlias(ctx, conf.ID, conf.Config.Alias)...)
+		errs = append(errs, m.validateDependsOn(ctx, conf.ID, conf.Config.DependsOn, batch)...)
+		errs = append(errs, m.validateNodeName(&conf.JobSpec, "")...)
+		if len(errs) > 0 {
+			result.Failed[conf.ID] = errs.ToAggregate().Error()
+			continue
+		}
+
+		exists := true
+		if err := m.client.Get(ctx, client.ObjectKey{Name: conf.ID}, new(v1beta1.Job)); err != nil {
+			if !apierrors.IsNotFound(err) {
+				result.Failed[conf.ID] = err.Error()
+				continue
+			}
+			exists = false
+		}
+		if exists && !overwrite {
+			result.Skipped = append(result.Skipped, conf.ID)
+			continue
+		}
+
+		job := v1beta1.Job{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Job",
+				APIVersion: v1beta1.GroupVersion.String(),
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: conf.ID,
+			},
+			Spec: conf.JobSpec,
+		}
+		err := m.ensureNamespace(ctx)
+		if err == nil {
+			err = m.client.Patch(ctx, &job, client.Apply, []client.PatchOption{client.ForceOwnership, client.FieldOwner("mirror-controller")}...)
+		}
+		if err != nil {
+			result.Failed[conf.ID] = m.namespaceError(err).Error()
+			continue
+		}
+		if exists {
+			result.Updated = append(result.Updated, conf.ID)
+		} else {
+			result.Created = append(result.Created, conf.ID)
+		}
+	}
+
+	runLog.Info(fmt.Sprintf("Imported mirrors: %d created, %d updated, %d skipped, %d failed",
+		len(result.Created), len(result.Updated), len(result.Skipped), len(result.Failed)))
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+)
+
+const gcInterval = 10 * time.Minute
+
+// collectGarbage drops the in-memory state of mirrors deleted out of
+// band every gcInterval, until ctx is done
+func (m *Manager) collectGarbage(ctx context.Context) {
+	m.runEvery(ctx, gcInterval, func() { m.sweep(ctx) })
+}
+
+// sweep checks the state kept per mirror against the jobs in the cache
+func (m *Manager) sweep(ctx context.Context) {
+	// an unsynced cache would make every mirror look deleted
+	if !m.cacheState.hasSynced() {
+		return
+	}
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(ctx, jobs); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to list jobs for garbage collection: %s", err.Error()))
+		return
+	}
+	live := make(map[string]bool, len(jobs.Items))
+	for _, v := range jobs.Items {
+		live[v.Name] = true
+	}
+	alive := func(name string) bool { return live[name] }
+
+	keys := m.idempotency.sweep(alive)
+	series := m.metrics.sweep(alive)
+	beats := m.heartbeats.sweep(alive)
+	if keys+series+beats > 0 {
+		runLog.Info(fmt.Sprintf("Collected %d idempotency keys, metrics of %d and heartbeats of %d deleted mirrors", keys, series, beats))
+	}
+}
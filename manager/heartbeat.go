@@ -0,0 +1,254 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var defaultOfflineAfterMisses = 3
+
+// heartbeats counts the heartbeats missed in a row per mirror
+type heartbeats struct {
+	mu     sync.Mutex
+	missed map[string]int
+	// status of the mirrors marked offline on missed heartbeats, set
+	// back when their workers answer again
+	marked map[string]v1beta1.SyncStatus
+}
+
+func newHeartbeats() *heartbeats {
+	return &heartbeats{
+		missed: make(map[string]int),
+		marked: make(map[string]v1beta1.SyncStatus),
+	}
+}
+
+// miss counts a missed heartbeat and returns the misses in a row
+func (h *heartbeats) miss(mirror string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.missed[mirror]++
+	return h.missed[mirror]
+}
+
+// seen resets the misses, and returns the status to restore if the mirror
+// was marked offline
+func (h *heartbeats) seen(mirror string) (v1beta1.SyncStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.missed, mirror)
+	from, ok := h.marked[mirror]
+	delete(h.marked, mirror)
+	return from, ok
+}
+
+func (h *heartbeats) mark(mirror string, from v1beta1.SyncStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.marked[mirror] = from
+}
+
+// reset drops the misses of the mirror
+func (h *heartbeats) reset(mirror string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.missed, mirror)
+}
+
+// forget drops all state of the mirror, e.g. when its worker goes
+// offline on purpose
+func (h *heartbeats) forget(mirror string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.missed, mirror)
+	delete(h.marked, mirror)
+}
+
+// heartbeatState is the state of a mirror as shown by /debug/state
+type heartbeatState struct {
+	Missed int                `json:"missed"`
+	Marked v1beta1.SyncStatus `json:"marked,omitempty"`
+}
+
+func (h *heartbeats) snapshot() map[string]heartbeatState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state := make(map[string]heartbeatState, len(h.missed))
+	for mirror, n := range h.missed {
+		state[mirror] = heartbeatState{Missed: n}
+	}
+	for mirror, from := range h.marked {
+		s := state[mirror]
+		s.Marked = from
+		state[mirror] = s
+	}
+	return state
+}
+
+// sweep drops the state of mirrors no longer alive
+func (h *heartbeats) sweep(alive func(mirror string) bool) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := 0
+	for mirror := range h.missed {
+		if !alive(mirror) {
+			delete(h.missed, mirror)
+			n++
+		}
+	}
+	for mirror := range h.marked {
+		if !alive(mirror) {
+			delete(h.marked, mirror)
+		}
+	}
+	return n
+}
+
+// watchHeartbeats pings the worker of every mirror each
+// Options.HeartbeatInterval until ctx is done, disabled when zero
+func (m *Manager) watchHeartbeats(ctx context.Context) {
+	if m.options().HeartbeatInterval.Duration <= 0 {
+		return
+	}
+	m.runEvery(ctx, m.options().HeartbeatInterval.Duration, func() {
+		m.checkHeartbeats(ctx)
+		m.saveState(ctx)
+	})
+}
+
+// checkHeartbeats marks a mirror offline after Options.OfflineAfterMisses
+// pings missed in a row, a single miss may be a restarting worker or a
+// network blip
+func (m *Manager) checkHeartbeats(ctx context.Context) {
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(ctx, jobs); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to list jobs for heartbeats: %s", err.Error()))
+		return
+	}
+	var ids []string
+	for _, v := range jobs.Items {
+		switch v.Spec.Config.Type {
+		case "", v1beta1.Mirror:
+		default:
+			continue
+		}
+		switch v.Status.Status {
+		case v1beta1.Paused, v1beta1.Disabled:
+			continue
+		}
+		ids = append(ids, v.Name)
+	}
+
+	limit := m.options().CmdConcurrency
+	if limit <= 0 {
+		limit = len(ids)
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if _, err := m.postCmd(ctx, id, internal.ClientCmd{Cmd: internal.CmdPing}); err != nil {
+				// a mirror failing to be marked is tried again on the
+				// next miss, the misses are reset once it's marked
+				if n := m.heartbeats.miss(id); n >= m.options().OfflineAfterMisses && m.markOffline(ctx, id, n, err) {
+					m.heartbeats.reset(id)
+				}
+				return
+			}
+			m.heartbeatSeen(ctx, id)
+		}(id)
+	}
+	wg.Wait()
+}
+
+// markOffline sets the mirror offline on missed heartbeats, remembering
+// the status to restore. It returns false when marking failed and is to
+// be tried again.
+func (m *Manager) markOffline(ctx context.Context, mirrorID string, misses int, cause error) bool {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+
+	job := new(v1beta1.Job)
+	if err := m.client.Get(ctx, client.ObjectKey{Name: mirrorID}, job); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to get job %s: %s", mirrorID, err.Error()))
+		return false
+	}
+	from := job.Status.Status
+	switch from {
+	case v1beta1.Offline, v1beta1.Paused, v1beta1.Disabled:
+		return true
+	}
+	// not retried, the status can't become offline
+	if err := applyStatusTransition(&job.Status, v1beta1.Offline); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to set mirror <%s> offline: %s", mirrorID, err.Error()))
+		return true
+	}
+	if err := m.client.Status().Update(ctx, job); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to set mirror <%s> offline: %s", mirrorID, err.Error()))
+		return false
+	}
+	m.heartbeats.mark(mirrorID, from)
+	runLog.Info(fmt.Sprintf("Mirror <%s> offline after %d missed heartbeats: %s", mirrorID, misses, cause.Error()))
+	m.recordEvent(ctx, job, corev1.EventTypeWarning, "Offline", fmt.Sprintf("%d heartbeats missed: %s", misses, cause.Error()))
+	return true
+}
+
+// heartbeatSeen resets the misses of the mirror, and restores the status
+// if it was marked offline on missed heartbeats
+func (m *Manager) heartbeatSeen(ctx context.Context, mirrorID string) {
+	from, ok := m.heartbeats.seen(mirrorID)
+	if !ok {
+		return
+	}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+
+	job := new(v1beta1.Job)
+	if err := m.client.Get(ctx, client.ObjectKey{Name: mirrorID}, job); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to get job %s: %s", mirrorID, err.Error()))
+		return
+	}
+	// reported another status meanwhile
+	if job.Status.Status != v1beta1.Offline {
+		return
+	}
+	job.Status.Status = from
+	job.Status.LastOnline = m.now().Unix()
+	if err := m.client.Status().Update(ctx, job); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to restore mirror <%s>: %s", mirrorID, err.Error()))
+		return
+	}
+	runLog.Info(fmt.Sprintf("Mirror <%s> back online as %s", mirrorID, from))
+}
@@ -0,0 +1,217 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	idempotencyTTL    = 10 * time.Minute
+	idempotencySize   = 1024
+)
+
+var errRequestInProgress = errors.New("a request with the same idempotency key is in progress")
+
+type idempotencyEntry struct {
+	key     string
+	mirror  string
+	expire  time.Time
+	pending bool
+
+	code        int
+	contentType string
+	body        []byte
+}
+
+// idempotencyCache is a small LRU remembering the responses of
+// requests carrying an Idempotency-Key
+type idempotencyCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newIdempotencyCache(ttl time.Duration, size int) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:   ttl,
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// begin returns the remembered entry of key, or reserves key for the
+// command to mirror and returns nil if it's not seen yet
+func (ic *idempotencyCache) begin(key, mirror string) *idempotencyEntry {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if el, ok := ic.items[key]; ok {
+		e := el.Value.(*idempotencyEntry)
+		if time.Now().Before(e.expire) {
+			ic.ll.MoveToFront(el)
+			entry := *e
+			return &entry
+		}
+		ic.ll.Remove(el)
+		delete(ic.items, key)
+	}
+
+	ic.items[key] = ic.ll.PushFront(&idempotencyEntry{key: key, mirror: mirror, expire: time.Now().Add(ic.ttl), pending: true})
+	for ic.ll.Len() > ic.size {
+		oldest := ic.ll.Back()
+		ic.ll.Remove(oldest)
+		delete(ic.items, oldest.Value.(*idempotencyEntry).key)
+	}
+	return nil
+}
+
+// finish stores the response of a reserved key
+func (ic *idempotencyCache) finish(key string, code int, contentType string, body []byte) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if el, ok := ic.items[key]; ok {
+		e := el.Value.(*idempotencyEntry)
+		e.pending = false
+		e.code, e.contentType, e.body = code, contentType, body
+	}
+}
+
+// idempotencyKey is a remembered key as shown by /debug/state
+type idempotencyKey struct {
+	Path    string    `json:"path"`
+	Key     string    `json:"key"`
+	Mirror  string    `json:"mirror,omitempty"`
+	Pending bool      `json:"pending"`
+	Expire  time.Time `json:"expire"`
+}
+
+// keys lists the unexpired keys, most recent first
+func (ic *idempotencyCache) keys() []idempotencyKey {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	keys := make([]idempotencyKey, 0, ic.ll.Len())
+	now := time.Now()
+	for el := ic.ll.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*idempotencyEntry)
+		if now.After(e.expire) {
+			continue
+		}
+		path, key, _ := strings.Cut(e.key, "\x00")
+		keys = append(keys, idempotencyKey{Path: path, Key: key, Mirror: e.mirror, Pending: e.pending, Expire: e.expire})
+	}
+	return keys
+}
+
+// forget drops a key so that the request can be retried
+func (ic *idempotencyCache) forget(key string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if el, ok := ic.items[key]; ok {
+		ic.ll.Remove(el)
+		delete(ic.items, key)
+	}
+}
+
+// sweep drops the expired keys and the keys of mirrors no longer alive,
+// pending keys are kept for their requests to finish
+func (ic *idempotencyCache) sweep(alive func(mirror string) bool) int {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	n := 0
+	now := time.Now()
+	for el := ic.ll.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*idempotencyEntry)
+		if !e.pending && (now.After(e.expire) || (e.mirror != "" && !alive(e.mirror))) {
+			ic.ll.Remove(el)
+			delete(ic.items, e.key)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+type recordWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordWriter) WriteString(s string) (int, error) {
+	w.buf.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent replays the original response of a request with a known
+// Idempotency-Key instead of handling it again
+func (m *Manager) idempotent(c *gin.Context) {
+	key := c.GetHeader(idempotencyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	key = c.Request.URL.Path + "\x00" + key
+
+	if e := m.idempotency.begin(key, c.Param("id")); e != nil {
+		if e.pending {
+			err := errRequestInProgress
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusConflict, err)
+			c.Abort()
+			return
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(e.code, e.contentType, e.body)
+		c.Abort()
+		return
+	}
+
+	w := &recordWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	// server errors are not remembered so that the client can retry
+	if w.Status() >= http.StatusInternalServerError {
+		m.idempotency.forget(key)
+		return
+	}
+	m.idempotency.finish(key, w.Status(), w.Header().Get("Content-Type"), w.buf.Bytes())
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultInterval is the interval in minutes of mirrors setting none
+// unless Options.DefaultInterval is set, what workers have defaulted to
+const defaultInterval = 1440
+
+// globalInterval is the interval of mirrors setting none, changed at
+// runtime through /config/default-interval
+type globalInterval struct {
+	v atomic.Int64
+}
+
+func (g *globalInterval) get() int {
+	return int(g.v.Load())
+}
+
+func (g *globalInterval) set(interval int) {
+	g.v.Store(int64(interval))
+}
+
+func validateInterval(interval int) error {
+	if interval < minInterval || interval > maxInterval {
+		return fmt.Errorf("interval %d must be between %d and %d minutes", interval, minInterval, maxInterval)
+	}
+	return nil
+}
+
+// withDefaultInterval sets the interval of a mirror setting none as its
+// worker resolves it, the types without syncs are left alone
+func (m *Manager) withDefaultInterval(cfg *v1beta1.JobConfig) {
+	if cfg.Interval != 0 || cfg.Type == v1beta1.Proxy || cfg.Type == v1beta1.External {
+		return
+	}
+	cfg.Interval = m.defaultInterval.get()
+}
+
+func (m *Manager) getDefaultInterval(c *gin.Context) {
+	c.JSON(http.StatusOK, internal.DefaultInterval{Interval: m.defaultInterval.get()})
+}
+
+// setDefaultInterval changes the interval of the mirrors setting none,
+// workers pick it up as they schedule their next sync
+func (m *Manager) setDefaultInterval(c *gin.Context) {
+	var d internal.DefaultInterval
+	if !m.bindJSON(c, &d) {
+		return
+	}
+	if err := validateInterval(d.Interval); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	m.defaultInterval.set(d.Interval)
+	m.saveState(c.Request.Context())
+
+	runLog.Info(fmt.Sprintf("Default interval set to %d minutes by %s", d.Interval, c.GetString(identityKey)))
+	c.JSON(http.StatusOK, d)
+}
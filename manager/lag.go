@@ -0,0 +1,198 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// maxMarkerSize is the most read of an upstream marker, the timestamp is
+// on its first line
+const maxMarkerSize = 4 * internal.K
+
+// markerTTL is how long a fetched marker is served before it's fetched
+// again, failures included
+const markerTTL = 5 * time.Minute
+
+var errNoUpstreamMarker = errors.New("no upstream marker")
+
+// checkMarkerHost checks the marker is on the host of the upstream, so
+// that no mirror makes the manager fetch from elsewhere, e.g. services
+// within the cluster
+func checkMarkerHost(upstream string, marker *url.URL) error {
+	u, err := url.Parse(upstream)
+	if err != nil || u.Hostname() == "" || !strings.EqualFold(u.Hostname(), marker.Hostname()) {
+		return errors.New("must be on the host of the upstream")
+	}
+	return nil
+}
+
+type markerEntry struct {
+	url     string
+	time    time.Time
+	err     error
+	expires time.Time
+}
+
+// markerCache keeps the fetched marker per mirror for markerTTL
+type markerCache struct {
+	mu      sync.Mutex
+	entries map[string]markerEntry
+}
+
+func (mc *markerCache) get(mirror, url string, now time.Time) (markerEntry, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	e, ok := mc.entries[mirror]
+	if !ok || e.url != url || !now.Before(e.expires) {
+		return markerEntry{}, false
+	}
+	return e, true
+}
+
+func (mc *markerCache) set(mirror string, e markerEntry) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.entries == nil {
+		mc.entries = make(map[string]markerEntry)
+	}
+	mc.entries[mirror] = e
+}
+
+// markerLayouts are the layouts of the timestamps of upstream markers,
+// e.g. the output of date -u in the trace files of debian
+var markerLayouts = []string{time.RFC3339, time.UnixDate, time.RFC1123, time.RFC1123Z, time.ANSIC}
+
+// parseMarker reads the time of an upstream marker, either unix seconds or
+// a date on its first line
+func parseMarker(line string) (time.Time, bool) {
+	line = strings.TrimSpace(line)
+	if sec, err := strconv.ParseInt(line, 10, 64); err == nil && sec > 0 {
+		return time.Unix(sec, 0), true
+	}
+	for _, layout := range markerLayouts {
+		if t, err := time.Parse(layout, line); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// upstreamTime returns the time told by the marker of the mirror, fetched
+// at most once per markerTTL
+func (m *Manager) upstreamTime(c *gin.Context, mirrorID string, cfg *v1beta1.JobConfig) (time.Time, error) {
+	if e, ok := m.markers.get(mirrorID, cfg.UpstreamMarker, m.now()); ok {
+		return e.time, e.err
+	}
+	// the spec may predate the check of the host
+	u, err := url.Parse(cfg.UpstreamMarker)
+	if err == nil {
+		err = checkMarkerHost(cfg.Upstream, u)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("upstream marker %s %w", cfg.UpstreamMarker, err)
+	}
+	t, err := m.fetchMarker(c, cfg.UpstreamMarker)
+	m.markers.set(mirrorID, markerEntry{url: cfg.UpstreamMarker, time: t, err: err, expires: m.now().Add(markerTTL)})
+	return t, err
+}
+
+// fetchMarker returns the time the upstream last changed as told by the
+// marker at url, its Last-Modified when the content is no timestamp
+func (m *Manager) fetchMarker(c *gin.Context, url string) (time.Time, error) {
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("upstream marker %s responded %d", url, resp.StatusCode)
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, maxMarkerSize)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return time.Time{}, err
+	}
+	if t, ok := parseMarker(line); ok {
+		return t, nil
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("upstream marker %s holds no timestamp", url)
+}
+
+// getJobLag compares the upstream marker of the mirror with its
+// LastUpdate, a mirror synced before the upstream last changed is behind
+// even when its syncs succeed
+func (m *Manager) getJobLag(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	m.rwmu.RLock()
+	job, err := m.GetJob(c, mirrorID)
+	m.rwmu.RUnlock()
+	if err != nil {
+		return
+	}
+	marker := job.Spec.Config.UpstreamMarker
+	if marker == "" {
+		err := fmt.Errorf("%w of mirror %s", errNoUpstreamMarker, mirrorID)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusNotFound, err)
+		return
+	}
+
+	upstream, err := m.upstreamTime(c, mirrorID, &job.Spec.Config)
+	if err != nil {
+		err := fmt.Errorf("failed to fetch the upstream marker of mirror %s: %w", mirrorID, err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadGateway, err)
+		return
+	}
+
+	lag := internal.MirrorLag{
+		ID:           mirrorID,
+		Marker:       marker,
+		UpstreamTime: upstream.Unix(),
+		LastUpdate:   job.Status.LastUpdate,
+	}
+	if lag.UpstreamTime > lag.LastUpdate {
+		lag.Behind = true
+		if lag.LastUpdate != 0 {
+			lag.Lag = lag.UpstreamTime - lag.LastUpdate
+		}
+	}
+	c.JSON(http.StatusOK, lag)
+}
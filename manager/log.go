@@ -0,0 +1,191 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// logLevel is the level of runLog once replaced by setupLogger, which
+// setLogLevel changes at runtime
+var logLevel = uberzap.NewAtomicLevel()
+
+// setupLogger replaces runLog with a logger of given level and format,
+// empty values keep the logger set up by the caller
+func setupLogger(level, format string) error {
+	if level == "" && format == "" {
+		return nil
+	}
+
+	opts := []zap.Opts{zap.Level(logLevel)}
+	if err := setLogLevel(level); err != nil {
+		return err
+	}
+	switch format {
+	case "":
+	case LogFormatJSON:
+		opts = append(opts, zap.JSONEncoder())
+	case LogFormatConsole:
+		opts = append(opts, zap.ConsoleEncoder())
+	default:
+		return fmt.Errorf("invalid log format %s", format)
+	}
+
+	runLog = zap.New(opts...).WithName("kubesync").WithName("run")
+	ownLogger = true
+	return nil
+}
+
+// ownLogger is set once runLog is replaced by setupLogger, the level of
+// the logger of the caller can't be changed
+var ownLogger bool
+
+// setLogLevel changes the level of runLog, an empty level is left alone
+func setLogLevel(level string) error {
+	if level == "" {
+		return nil
+	}
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %s: %s", level, err.Error())
+	}
+	logLevel.SetLevel(lvl)
+	return nil
+}
+
+// debugClient logs every call to the api server at debug level
+type debugClient struct {
+	Store
+}
+
+func (d debugClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	err := d.Store.Get(ctx, key, obj, opts...)
+	runLog.V(1).Info("client get", "key", key.String(), "error", err)
+	return err
+}
+
+func (d debugClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	err := d.Store.List(ctx, list, opts...)
+	runLog.V(1).Info("client list", "type", fmt.Sprintf("%T", list), "error", err)
+	return err
+}
+
+func (d debugClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	err := d.Store.Create(ctx, obj, opts...)
+	runLog.V(1).Info("client create", "name", obj.GetName(), "error", err)
+	return err
+}
+
+func (d debugClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	err := d.Store.Delete(ctx, obj, opts...)
+	runLog.V(1).Info("client delete", "name", obj.GetName(), "error", err)
+	return err
+}
+
+func (d debugClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	err := d.Store.Update(ctx, obj, opts...)
+	runLog.V(1).Info("client update", "name", obj.GetName(), "error", err)
+	return err
+}
+
+func (d debugClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := d.Store.Patch(ctx, obj, patch, opts...)
+	runLog.V(1).Info("client patch", "name", obj.GetName(), "patch", patch.Type(), "error", err)
+	return err
+}
+
+func (d debugClient) Status() client.SubResourceWriter {
+	return debugStatusWriter{d.Store.Status()}
+}
+
+type debugStatusWriter struct {
+	client.SubResourceWriter
+}
+
+func (d debugStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	err := d.SubResourceWriter.Update(ctx, obj, opts...)
+	runLog.V(1).Info("client status update", "name", obj.GetName(), "error", err)
+	return err
+}
+
+func (d debugStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	err := d.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+	runLog.V(1).Info("client status patch", "name", obj.GetName(), "patch", patch.Type(), "error", err)
+	return err
+}
+
+// bodies logged by logBody are cut at this length
+const maxLoggedBody = 4 * 1024
+
+// string values of json keys like token, password or secret
+var secretValue = regexp.MustCompile(`(?i)("[^"]*(?:token|password|secret)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// logBody logs the body of mutating requests at debug level, along with
+// the response code, to diagnose the bodies workers fail to serialize.
+// Only the logged head of the body is buffered, the handler reads it all.
+func logBody(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		c.Next()
+		return
+	}
+
+	head, err := io.ReadAll(io.LimitReader(c.Request.Body, maxLoggedBody+1))
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), c.Request.Body), c.Request.Body}
+	c.Next()
+
+	if err != nil {
+		runLog.V(1).Info("request body", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+		return
+	}
+	runLog.V(1).Info("request body", "method", c.Request.Method, "path", c.Request.URL.Path,
+		"code", c.Writer.Status(), "body", redactBody(head))
+}
+
+// redactBody truncates the body and hides the values of secret keys
+func redactBody(body []byte) string {
+	truncated := len(body) > maxLoggedBody
+	if truncated {
+		body = body[:maxLoggedBody]
+	}
+	s := secretValue.ReplaceAllString(string(body), `${1}"`+redacted+`"`)
+	if truncated {
+		s += "...(truncated)"
+	}
+	return s
+}
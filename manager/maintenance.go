@@ -0,0 +1,121 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceHeader tells the end of an ongoing maintenance on the status page
+const maintenanceHeader = "X-Maintenance-Until"
+
+var errInMaintenance = errors.New("in maintenance")
+
+// maintenanceWindow freezes the start of syncs between Start and End,
+// it clears itself once End has passed
+type maintenanceWindow struct {
+	mu sync.RWMutex
+	w  internal.Maintenance
+}
+
+func (mw *maintenanceWindow) get(now time.Time) internal.Maintenance {
+	mw.mu.RLock()
+	defer mw.mu.RUnlock()
+	w := mw.w
+	if w.End != 0 && now.Unix() >= w.End {
+		w = internal.Maintenance{}
+	}
+	w.Active = w.End != 0 && now.Unix() >= w.Start
+	return w
+}
+
+func (mw *maintenanceWindow) set(w internal.Maintenance) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.w = w
+}
+
+// checkMaintenance rejects a job starting a sync during maintenance or
+// while scheduling is paused, jobs already syncing are free to report
+// until they finish
+func (m *Manager) checkMaintenance(from, to v1beta1.SyncStatus) error {
+	if !startsSync(from, to) {
+		return nil
+	}
+	if err := m.scheduling.check(); err != nil {
+		return err
+	}
+	if w := m.maintenance.get(m.now()); w.Active {
+		return fmt.Errorf("%w until %s: %s", errInMaintenance, time.Unix(w.End, 0).Format(time.RFC3339), w.Reason)
+	}
+	return nil
+}
+
+// maintenanceInfo sets the maintenance header on the status page
+func (m *Manager) maintenanceInfo(c *gin.Context) {
+	if w := m.maintenance.get(m.now()); w.Active {
+		c.Header(maintenanceHeader, strconv.FormatInt(w.End, 10))
+	}
+	c.Next()
+}
+
+func (m *Manager) getMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, m.maintenance.get(m.now()))
+}
+
+// setMaintenance schedules a maintenance window, starting now when no
+// start is given
+func (m *Manager) setMaintenance(c *gin.Context) {
+	var w internal.Maintenance
+	if !m.bindJSON(c, &w) {
+		return
+	}
+
+	now := m.now().Unix()
+	if w.Start == 0 {
+		w.Start = now
+	}
+	if w.End <= w.Start || w.End <= now {
+		err := errors.New("end of maintenance must be after its start and now")
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	w.Active = false
+	m.maintenance.set(w)
+
+	runLog.Info(fmt.Sprintf("Maintenance from %s to %s set by %s: %s",
+		time.Unix(w.Start, 0).Format(time.RFC3339), time.Unix(w.End, 0).Format(time.RFC3339),
+		c.GetString(identityKey), w.Reason))
+	c.JSON(http.StatusOK, m.maintenance.get(m.now()))
+}
+
+func (m *Manager) clearMaintenance(c *gin.Context) {
+	m.maintenance.set(internal.Maintenance{})
+	runLog.Info(fmt.Sprintf("Maintenance cleared by %s", c.GetString(identityKey)))
+	c.JSON(http.StatusOK, gin.H{_infoKey: "maintenance cleared"})
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/prometheus/client_golang/prometheus"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// jobMetrics keeps the metrics of jobs up to date from informer events,
+// so that a scrape never lists jobs
+type jobMetrics struct {
+	registry *prometheus.Registry
+	// now is the clock of the manager
+	now func() time.Time
+
+	// mirrors with series, for sweep
+	mu      sync.Mutex
+	mirrors map[string]struct{}
+
+	status       *prometheus.GaugeVec
+	size         *prometheus.GaugeVec
+	lastUpdate   *prometheus.GaugeVec
+	lastEnded    *prometheus.GaugeVec
+	nextSchedule *prometheus.GaugeVec
+}
+
+func newJobMetrics(now func() time.Time) *jobMetrics {
+	jm := &jobMetrics{
+		now:      now,
+		registry: prometheus.NewRegistry(),
+		mirrors:  make(map[string]struct{}),
+		status: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kubesync_mirror_status",
+			Help: "Current sync status of the mirror, always 1",
+		}, []string{"mirror", "status"}),
+		size: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kubesync_mirror_size_bytes",
+			Help: "Size of the mirror",
+		}, []string{"mirror"}),
+		lastUpdate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kubesync_mirror_last_update_timestamp_seconds",
+			Help: "Time of the last successful sync",
+		}, []string{"mirror"}),
+		lastEnded: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kubesync_mirror_last_ended_timestamp_seconds",
+			Help: "Time of the last finished sync",
+		}, []string{"mirror"}),
+		nextSchedule: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kubesync_mirror_next_schedule_timestamp_seconds",
+			Help: "Time of the next scheduled sync",
+		}, []string{"mirror"}),
+	}
+	jm.registry.MustRegister(jm.status, jm.size, jm.lastUpdate, jm.lastEnded, jm.nextSchedule)
+	return jm
+}
+
+func (jm *jobMetrics) update(job *v1beta1.Job) {
+	w := mirrorStatus(job, jm.now())
+	jm.status.DeletePartialMatch(prometheus.Labels{"mirror": job.Name})
+	jm.status.WithLabelValues(job.Name, string(w.Status)).Set(1)
+	jm.size.WithLabelValues(job.Name).Set(float64(w.Size))
+	jm.lastUpdate.WithLabelValues(job.Name).Set(float64(w.LastUpdate))
+	jm.lastEnded.WithLabelValues(job.Name).Set(float64(w.LastEnded))
+	jm.nextSchedule.WithLabelValues(job.Name).Set(float64(w.Scheduled))
+
+	jm.mu.Lock()
+	jm.mirrors[job.Name] = struct{}{}
+	jm.mu.Unlock()
+}
+
+func (jm *jobMetrics) delete(name string) {
+	jm.status.DeletePartialMatch(prometheus.Labels{"mirror": name})
+	jm.size.DeleteLabelValues(name)
+	jm.lastUpdate.DeleteLabelValues(name)
+	jm.lastEnded.DeleteLabelValues(name)
+	jm.nextSchedule.DeleteLabelValues(name)
+
+	jm.mu.Lock()
+	delete(jm.mirrors, name)
+	jm.mu.Unlock()
+}
+
+// sweep deletes the series of mirrors no longer alive, in case a delete
+// event was missed
+func (jm *jobMetrics) sweep(alive func(mirror string) bool) int {
+	jm.mu.Lock()
+	var dead []string
+	for name := range jm.mirrors {
+		if !alive(name) {
+			dead = append(dead, name)
+		}
+	}
+	jm.mu.Unlock()
+
+	for _, name := range dead {
+		jm.delete(name)
+	}
+	return len(dead)
+}
+
+func (jm *jobMetrics) handler() toolscache.ResourceEventHandlerFuncs {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if job, ok := eventJob(obj); ok {
+				jm.update(job)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if job, ok := eventJob(newObj); ok {
+				jm.update(job)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if job, ok := eventJob(obj); ok {
+				jm.delete(job.Name)
+			}
+		},
+	}
+}
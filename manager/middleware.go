@@ -0,0 +1,277 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responses smaller than this are not worth compressing
+const gzipMinLength = 1024
+
+type gzipWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.buf.WriteString(s)
+}
+
+// defaultResponseHeaders keep browsers from sniffing, framing or running
+// anything of the responses, which are all json or plain text
+var defaultResponseHeaders = map[string]string{
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+	"Referrer-Policy":         "no-referrer",
+}
+
+// responseHeaders merges the headers of Options.ResponseHeaders over
+// defaultResponseHeaders
+func responseHeaders(custom map[string]string) map[string]string {
+	headers := make(map[string]string, len(defaultResponseHeaders)+len(custom))
+	for k, v := range defaultResponseHeaders {
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range custom {
+		if v == "" {
+			delete(headers, http.CanonicalHeaderKey(k))
+		} else {
+			headers[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	return headers
+}
+
+// setHeaders sets headers on every response before it's handled, so that
+// a handler may still override them
+func setHeaders(headers map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for k, v := range headers {
+			c.Header(k, v)
+		}
+		c.Next()
+	}
+}
+
+var errTooBusy = errors.New("too many requests in flight")
+
+var errCacheSyncing = errors.New("the cache of jobs is still syncing")
+
+// seconds to back off while the cache syncs
+const cacheRetryAfter = 5
+
+// unlimitedPaths are the routes of health checks and scrapes under
+// basePath, which must answer however busy the manager is
+func unlimitedPaths(basePath string) map[string]bool {
+	paths := make(map[string]bool)
+	for _, p := range []string{"/ping", "/cache/status", "/metrics"} {
+		paths[path.Join("/", basePath, p)] = true
+	}
+	return paths
+}
+
+// streamingPaths are the routes under basePath which hold the connection
+// open as long as the client likes
+func streamingPaths(basePath string) map[string]bool {
+	return map[string]bool{path.Join("/", basePath, "/jobs/watch"): true}
+}
+
+// requireCache answers 503 until the cache has synced, a read would miss
+// the jobs not listed yet and a write could overwrite them. The health
+// checks and scrapes answer meanwhile.
+func (m *Manager) requireCache(unlimited map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m.ready.Load() || unlimited[c.FullPath()] {
+			c.Next()
+			return
+		}
+		c.Header(retryAfterHeader, strconv.Itoa(cacheRetryAfter))
+		c.Error(errCacheSyncing)
+		m.returnErrJSON(c, http.StatusServiceUnavailable, errCacheSyncing)
+		c.Abort()
+	}
+}
+
+// limitInFlight answers 503 to a request when Options.MaxConcurrentRequests
+// are being handled already, instead of queueing it, so that a herd of
+// workers reporting at once can't pile up on the manager and the api
+// server behind it. Streams take slots of their own, or a few watchers
+// would hold those of the requests for good.
+func (m *Manager) limitInFlight(unlimited, streaming map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slots := m.inFlight
+		switch {
+		case unlimited[c.FullPath()]:
+			c.Next()
+			return
+		case streaming[c.FullPath()]:
+			slots = m.streams
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			c.Header(retryAfterHeader, strconv.Itoa(conflictRetryAfter))
+			c.Error(errTooBusy)
+			m.returnErrJSON(c, http.StatusServiceUnavailable, errTooBusy)
+			c.Abort()
+		}
+	}
+}
+
+// limitBody rejects POST, PUT and PATCH requests with a body larger than
+// Options.MaxBodyBytes, the body is buffered so that handlers binding it
+// never see a truncated one
+func (m *Manager) limitBody(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		c.Next()
+		return
+	}
+
+	limit := m.options().MaxBodyBytes
+	tooLarge := func() {
+		err := fmt.Errorf("request body exceeds %d bytes", limit)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusRequestEntityTooLarge, err)
+		c.Abort()
+	}
+	if c.Request.ContentLength > limit {
+		tooLarge()
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			tooLarge()
+			return
+		}
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	c.Request.ContentLength = int64(len(body))
+	c.Next()
+}
+
+// errReadOnly rejects the mutating requests of Options.ReadOnly
+var errReadOnly = errors.New("manager is read-only")
+
+// rejectWrites responds 403 to any request other than GET, HEAD is
+// rejected too as a worker registers by it
+func (m *Manager) rejectWrites(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		c.Next()
+		return
+	}
+	c.Error(errReadOnly)
+	m.returnErrJSON(c, http.StatusForbidden, errReadOnly)
+	c.Abort()
+}
+
+// mergePatchJSON is the type of the json merge patches of job statuses
+const mergePatchJSON = "application/merge-patch+json"
+
+// requireJSON rejects mutating requests carrying a body of any type other
+// than json, or a json merge patch, with 415, bodiless ones pass through
+func (m *Manager) requireJSON(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		c.Next()
+		return
+	}
+	if c.Request.ContentLength == 0 {
+		c.Next()
+		return
+	}
+
+	contentType := c.GetHeader("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || (mediaType != gin.MIMEJSON && mediaType != mergePatchJSON) {
+		err := fmt.Errorf("unsupported content type %q, want %s", contentType, gin.MIMEJSON)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusUnsupportedMediaType, err)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// bindJSON decodes the body into obj, a malformed one is answered with 400
+// and false is returned so the handler can bail out
+func (m *Manager) bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		err := fmt.Errorf("invalid request body: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// gzipCompress buffers the response and compresses it when the client
+// accepts gzip and the body is large enough
+func gzipCompress(c *gin.Context) {
+	if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		c.Next()
+		return
+	}
+
+	w := &gzipWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	body := w.buf.Bytes()
+	c.Header("Vary", "Accept-Encoding")
+	if len(body) < gzipMinLength {
+		c.Writer.Write(body)
+		return
+	}
+
+	c.Header("Content-Encoding", "gzip")
+	c.Writer.Header().Del("Content-Length")
+	gz := gzip.NewWriter(c.Writer)
+	defer gz.Close()
+	if _, err := gz.Write(body); err != nil {
+		c.Error(err)
+	}
+}
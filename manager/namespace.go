@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	errNamespaceMissing   = errors.New("namespace of the jobs does not exist")
+	errNamespaceForbidden = errors.New("manager is not allowed to create the namespace of the jobs")
+)
+
+// ensureNamespace creates the namespace of the jobs if it's missing when
+// Options.EnsureNamespace is set, so that the first job of a fresh
+// cluster can be created. It's checked once per run, and never for the
+// namespace of the pod of the manager.
+//
+// The manager runs under a namespaced Role, which can't get namespaces,
+// so the create is tried at once. A create it's not allowed to is taken
+// as the namespace existing, creating the job tells otherwise.
+func (m *Manager) ensureNamespace(ctx context.Context) error {
+	if !m.options().EnsureNamespace || m.options().Storage == StorageMemory || m.namespaceReady.Load() {
+		return nil
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: m.namespace}}
+	err := m.client.Create(ctx, ns)
+	switch {
+	case err == nil:
+		runLog.Info("Created namespace " + m.namespace)
+	case apierrors.IsAlreadyExists(err):
+	case apierrors.IsForbidden(err):
+		m.namespaceForbidden.Store(true)
+	default:
+		return fmt.Errorf("failed to create namespace %s: %w", m.namespace, err)
+	}
+	m.namespaceReady.Store(true)
+	return nil
+}
+
+// namespaceError explains the not found error of creating a job, which
+// means the namespace of the jobs is missing rather than the job
+func (m *Manager) namespaceError(err error) error {
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	if m.namespaceForbidden.Load() {
+		return fmt.Errorf("%w %s: grant it create on namespaces or create it by hand (%s)", errNamespaceForbidden, m.namespace, err.Error())
+	}
+	return fmt.Errorf("%w: create namespace %s or set ensureNamespace (%s)", errNamespaceMissing, m.namespace, err.Error())
+}
+
+// podNamespace is the namespace of the pod the manager runs in, empty
+// out of a pod
+func podNamespace() string {
+	b, err := os.ReadFile(serviceAccountNamespace)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
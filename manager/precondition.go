@@ -0,0 +1,75 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ifMatchHeader = "If-Match"
+	etagHeader    = "ETag"
+)
+
+// ifMatchVersion is the resourceVersion the request is conditional on,
+// empty when it's unconditional
+func ifMatchVersion(c *gin.Context) string {
+	v := strings.TrimSpace(c.GetHeader(ifMatchHeader))
+	if v == "*" {
+		return ""
+	}
+	return strings.Trim(strings.TrimPrefix(v, "W/"), `"`)
+}
+
+// ifMatch checks the If-Match header against the resourceVersion of job,
+// as returned by getJob and listJob, and responds 409 when the job has
+// changed since. A nil job is one which doesn't exist.
+func (m *Manager) ifMatch(c *gin.Context, mirrorID string, job *v1beta1.Job) bool {
+	want := ifMatchVersion(c)
+	if want == "" {
+		return true
+	}
+	var err error
+	switch {
+	case job == nil:
+		err = fmt.Errorf("job %s does not exist, expected resourceVersion %s", mirrorID, want)
+	case job.ResourceVersion != want:
+		err = fmt.Errorf("job %s has changed, resourceVersion is %s instead of %s", mirrorID, job.ResourceVersion, want)
+	default:
+		return true
+	}
+	c.Error(err)
+	m.returnErrJSON(c, http.StatusConflict, err)
+	return false
+}
+
+// mergeFrom is client.MergeFrom, which also makes the api server reject
+// the patch when the job changes after it's read, if the request is
+// conditional. ifMatch only checks the version in the cache.
+func mergeFrom(c *gin.Context, job *v1beta1.Job) client.Patch {
+	if ifMatchVersion(c) == "" {
+		return client.MergeFrom(job)
+	}
+	return client.MergeFromWithOptions(job, client.MergeFromWithOptimisticLock{})
+}
@@ -0,0 +1,188 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// reloadKey is the key of the ConfigMap of Options.ReloadConfigMap holding
+// the options, in yaml or json like the config file
+const reloadKey = "config.yaml"
+
+// reloadableOptions are the json keys of the options taken from the
+// ConfigMap without a restart, the others are logged as ignored
+var reloadableOptions = map[string]bool{
+	"logLevel": true, "adminToken": true, "cmdSecret": true, "signedCmds": true,
+	"cmdTimeout": true, "cmdRetries": true, "cmdConcurrency": true,
+	"offlineAfterMisses": true, "autoPauseFailures": true, "historyLimit": true,
+	"maxBodyBytes": true, "unknownStatus": true, "statusConflict": true,
+}
+
+// options returns the options in effect, never changed but replaced whole
+// on reload
+func (m *Manager) options() *Options {
+	return m.option.Load()
+}
+
+// watchConfig reloads the options on every change of the ConfigMap of
+// Options.ReloadConfigMap, disabled when unset
+func (m *Manager) watchConfig(ctx context.Context) {
+	name := m.options().ReloadConfigMap
+	if name == "" {
+		return
+	}
+	informer, err := m.cache.GetInformer(ctx, &corev1.ConfigMap{})
+	if err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to watch configmap %s, options are not reloaded: %s", name, err.Error()))
+		return
+	}
+	reload := func(obj interface{}) {
+		if cm, ok := obj.(*corev1.ConfigMap); ok && cm.Name == name {
+			m.reloadConfig(cm)
+		}
+	}
+	if _, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: reload,
+		UpdateFunc: func(old, obj interface{}) {
+			// a resync of the informer changes nothing
+			if o, ok := old.(*corev1.ConfigMap); ok && o.ResourceVersion == obj.(*corev1.ConfigMap).ResourceVersion {
+				return
+			}
+			reload(obj)
+		},
+	}); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to watch configmap %s, options are not reloaded: %s", name, err.Error()))
+	}
+}
+
+// reloadConfig applies the reloadable options set in the ConfigMap, the
+// options left out keep their value. Nothing is applied if any is invalid.
+func (m *Manager) reloadConfig(cm *corev1.ConfigMap) {
+	data, ok := cm.Data[reloadKey]
+	if !ok {
+		return
+	}
+	raw, err := yaml.YAMLToJSON([]byte(data))
+	if err != nil {
+		runLog.Error(err, fmt.Sprintf("Invalid options in configmap %s: %s", cm.Name, err.Error()))
+		return
+	}
+	var set map[string]json.RawMessage
+	if err = json.Unmarshal(raw, &set); err != nil {
+		runLog.Error(err, fmt.Sprintf("Invalid options in configmap %s: %s", cm.Name, err.Error()))
+		return
+	}
+
+	known := jsonFields(reflect.TypeOf(Options{}))
+	reloaded := make(map[string]json.RawMessage)
+	var ignored []string
+	for k, v := range set {
+		switch {
+		case !known[k]:
+			runLog.Info(fmt.Sprintf("Ignored unknown option %s in configmap %s", k, cm.Name))
+		case reloadableOptions[k]:
+			reloaded[k] = v
+		default:
+			ignored = append(ignored, k)
+		}
+	}
+
+	cur := m.options()
+	next := *cur
+	b, _ := json.Marshal(reloaded)
+	if err = json.Unmarshal(b, &next); err != nil {
+		runLog.Error(err, fmt.Sprintf("Invalid options in configmap %s: %s", cm.Name, err.Error()))
+		return
+	}
+	if err = validateReloaded(&next); err != nil {
+		runLog.Error(err, fmt.Sprintf("Invalid options in configmap %s: %s", cm.Name, err.Error()))
+		return
+	}
+	// left alone when unchanged, so the ignored ones aren't logged again
+	if optionsEqual(cur, &next) && len(ignored) == 0 {
+		return
+	}
+	if next.LogLevel != cur.LogLevel {
+		if ownLogger {
+			_ = setLogLevel(next.LogLevel)
+		} else {
+			runLog.Info("Ignored logLevel in configmap " + cm.Name + ", the logger is set up by the caller")
+		}
+	}
+	m.option.Store(&next)
+
+	changed := make([]string, 0, len(reloaded))
+	for k := range reloaded {
+		changed = append(changed, k)
+	}
+	sort.Strings(changed)
+	runLog.Info(fmt.Sprintf("Reloaded options %s from configmap %s", strings.Join(changed, ", "), cm.Name))
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		runLog.Info(fmt.Sprintf("Ignored options %s in configmap %s, they take a restart", strings.Join(ignored, ", "), cm.Name))
+	}
+}
+
+// validateReloaded validates and defaults the reloadable options the same
+// as GetTUNASyncManager
+func validateReloaded(o *Options) error {
+	if o.LogLevel != "" {
+		if _, err := zapcore.ParseLevel(o.LogLevel); err != nil {
+			return fmt.Errorf("invalid log level %s: %s", o.LogLevel, err.Error())
+		}
+	}
+	if err := validateConflictOptions(o); err != nil {
+		return err
+	}
+	if err := validateSignedCmds(o); err != nil {
+		return err
+	}
+	if o.HistoryLimit <= 0 {
+		o.HistoryLimit = defaultHistoryLimit
+	}
+	if o.MaxBodyBytes <= 0 {
+		o.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if o.CmdTimeout.Duration <= 0 {
+		o.CmdTimeout.Duration = defaultCmdTimeout
+	}
+	if o.OfflineAfterMisses <= 0 {
+		o.OfflineAfterMisses = defaultOfflineAfterMisses
+	}
+	return nil
+}
+
+// optionsEqual compares options by their json, which leaves out the
+// injected clients
+func optionsEqual(a, b *Options) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ja, jb)
+}
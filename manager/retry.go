@@ -0,0 +1,48 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+)
+
+// maxBackoffShift caps the doubling of the retry interval
+const maxBackoffShift = 16
+
+// nextRetry returns the time to retry after the given consecutive failures,
+// with exponential backoff from RetryInterval. Zero means no retry is
+// recommended and the job waits for its usual interval.
+func nextRetry(cfg *v1beta1.JobConfig, failures int, now int64) int64 {
+	if cfg.RetryInterval <= 0 || failures <= 0 {
+		return 0
+	}
+	if cfg.MaxRetries > 0 && failures > cfg.MaxRetries {
+		return 0
+	}
+
+	shift := failures - 1
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+	backoff := int64(cfg.RetryInterval) << shift
+	// never wait longer than the usual interval
+	if cfg.Interval > 0 && backoff > int64(cfg.Interval)*60 {
+		backoff = int64(cfg.Interval) * 60
+	}
+	return now + backoff
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+var errSchedulingPaused = errors.New("scheduling paused")
+
+// schedulingState pauses the start of syncs until resumed, unlike a
+// maintenance window it has no end
+type schedulingState struct {
+	mu sync.RWMutex
+	s  internal.Scheduling
+}
+
+func (ss *schedulingState) get() internal.Scheduling {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.s
+}
+
+func (ss *schedulingState) set(s internal.Scheduling) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.s = s
+}
+
+// check returns errSchedulingPaused while paused
+func (ss *schedulingState) check() error {
+	if s := ss.get(); s.Paused {
+		return fmt.Errorf("%w since %s by %s: %s", errSchedulingPaused, time.Unix(s.Since, 0).Format(time.RFC3339), s.By, s.Reason)
+	}
+	return nil
+}
+
+func (m *Manager) getScheduling(c *gin.Context) {
+	c.JSON(http.StatusOK, m.scheduling.get())
+}
+
+// pauseScheduling stops new syncs from starting, running syncs finish and
+// report as usual, an optional reason is taken from the body
+func (m *Manager) pauseScheduling(c *gin.Context) {
+	var s internal.Scheduling
+	if c.Request.ContentLength != 0 {
+		if !m.bindJSON(c, &s) {
+			return
+		}
+	}
+	s.Paused = true
+	s.Since = m.now().Unix()
+	s.By = c.GetString(identityKey)
+	m.scheduling.set(s)
+
+	runLog.Info(fmt.Sprintf("Scheduling paused by %s: %s", s.By, s.Reason))
+	c.JSON(http.StatusOK, s)
+}
+
+func (m *Manager) resumeScheduling(c *gin.Context) {
+	m.scheduling.set(internal.Scheduling{})
+	runLog.Info(fmt.Sprintf("Scheduling resumed by %s", c.GetString(identityKey)))
+	c.JSON(http.StatusOK, m.scheduling.get())
+}
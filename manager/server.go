@@ -26,52 +26,225 @@ import (
 	"github.com/CQUPTMirror/kubesync/manager/mirrorz"
 	"io"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
 	"net/http"
-	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/CQUPTMirror/kubesync/api/v1beta1"
 	"github.com/CQUPTMirror/kubesync/internal"
 	"github.com/CQUPTMirror/kubesync/manager/external"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	kubelog "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+// mirrorStatusHeader carries the sync status in the response of a HEAD
+const mirrorStatusHeader = "X-Mirror-Status"
+
 const (
 	_errorKey = "error"
+	_codeKey  = "code"
 	_infoKey  = "message"
 )
 
 var (
-	defaultRetryPeriod = 2 * time.Second
-	runLog             = kubelog.Log.WithName("kubesync").WithName("run")
+	defaultAddress       = ":3000"
+	defaultServerTimeout = 10 * time.Second
+	defaultCmdTimeout    = 5 * time.Second
+	// doubled on every retry of a command
+	defaultCmdBackoff   = 500 * time.Millisecond
+	defaultRetryPeriod  = 2 * time.Second
+	defaultHistoryLimit = 30
+	// large enough for a full log tail and history
+	defaultMaxBodyBytes int64 = 4 * internal.M
+	// size drop in percent flagged by size-trend
+	defaultShrinkPercent = "10"
+	// mirrors not updated within this are stale
+	defaultStaleThreshold = "24h"
+	// mounted into every pod with a service account
+	serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	runLog                  = kubelog.Log.WithName("kubesync").WithName("run")
 )
 
+// Options configures the manager, see LoadOptionsFromFile for the keys
 type Options struct {
-	Scheme  *runtime.Scheme
-	Address string
-	MirrorZ *mirrorz.MirrorZ
-	Total   string
+	// Client and Cache replace the connection to the api server when
+	// both set, e.g. a client of fake.NewClientBuilder and
+	// informertest.FakeInformers for testing, the rest config is unused
+	Client client.Client `json:"-"`
+	Cache  cache.Cache   `json:"-"`
+
+	Scheme  *runtime.Scheme  `json:"-"`
+	Address string           `json:"address,omitempty"`
+	MirrorZ *mirrorz.MirrorZ `json:"mirrorz,omitempty"`
+	Total   string           `json:"total,omitempty"`
+	// Namespace of the jobs, the namespace of the pod when empty
+	Namespace string `json:"namespace,omitempty"`
+	// HistoryLimit is the max number of sync records kept per job
+	HistoryLimit int `json:"historyLimit,omitempty"`
+	// BasePath is the prefix of all routes, e.g. /mirror-api
+	BasePath string `json:"basePath,omitempty"`
+	// LogLevel is one of debug, info, warn and error
+	LogLevel string `json:"logLevel,omitempty"`
+	// LogFormat is json or console
+	LogFormat string `json:"logFormat,omitempty"`
+	// AdminToken guards the admin routes as a bearer token
+	AdminToken string `json:"adminToken,omitempty"`
+	// MaxBodyBytes is the max size of a request body
+	MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+	// AutoPauseFailures pauses a job after this many consecutive failures,
+	// zero disables it
+	AutoPauseFailures int `json:"autoPauseFailures,omitempty"`
+	// CmdConcurrency limits the commands posted at once by /jobs/cmd,
+	// zero means no limit
+	CmdConcurrency int `json:"cmdConcurrency,omitempty"`
+	// ReadTimeout and WriteTimeout of the http server, 10s by default
+	ReadTimeout  metav1.Duration `json:"readTimeout,omitempty"`
+	WriteTimeout metav1.Duration `json:"writeTimeout,omitempty"`
+	// TLSCertFile and TLSKeyFile serve https when both are set
+	TLSCertFile string `json:"tlsCertFile,omitempty"`
+	TLSKeyFile  string `json:"tlsKeyFile,omitempty"`
+	// CmdRetries is the times a command is retried when the worker is
+	// unreachable, zero means no retry
+	CmdRetries int `json:"cmdRetries,omitempty"`
+	// CmdTimeout of posting a command to a worker, 5s by default
+	CmdTimeout metav1.Duration `json:"cmdTimeout,omitempty"`
+	// TracingEndpoint is the otlp http endpoint receiving request traces,
+	// tracing is off when empty
+	TracingEndpoint string `json:"tracingEndpoint,omitempty"`
+	// AuditLog is the file the mutating requests are appended to, or
+	// stdout, auditing is off when empty
+	AuditLog string `json:"auditLog,omitempty"`
+	// HeartbeatInterval is how often the workers are pinged, zero
+	// disables the heartbeats
+	HeartbeatInterval metav1.Duration `json:"heartbeatInterval,omitempty"`
+	// OfflineAfterMisses is the heartbeats missed in a row before a
+	// mirror is set offline, 3 by default
+	OfflineAfterMisses int `json:"offlineAfterMisses,omitempty"`
+	// Storage is kubernetes by default, or memory to run without an api
+	// server, see StorageMemory
+	Storage string `json:"storage,omitempty"`
+	// TrustedProxies are the ips or cidrs of the proxies in front, whose
+	// X-Forwarded-For gives the client ip, no proxy is trusted when empty
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+	// LogBodies logs the bodies of mutating requests at debug level,
+	// truncated and with secrets redacted, off by default as they may
+	// hold private data
+	LogBodies bool `json:"logBodies,omitempty"`
+	// SignedCmds requires command posts to be signed by internal.SignCmd
+	// with CmdSecret, each nonce used once within a few minutes
+	SignedCmds bool   `json:"signedCmds,omitempty"`
+	CmdSecret  string `json:"cmdSecret,omitempty"`
+	// EnsureNamespace creates the namespace of the jobs if it's missing
+	// when a job is created, which needs create on namespaces granted
+	// by a ClusterRole. The namespace of the pod is never checked.
+	EnsureNamespace bool `json:"ensureNamespace,omitempty"`
+	// ReadOnly rejects every request other than GET with 403, e.g. for a
+	// public replica serving the status page. It's checked before auth,
+	// so neither the admin nor a worker token lifts it, while the GET
+	// routes guarded by the admin token still require it. The background
+	// loops writing jobs, e.g. heartbeats, don't run either.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// LoopJitter stretches every period of the background loops and the
+	// cache resync by a random part of up to this fraction, e.g. 0.1, so
+	// that several managers don't hit the api server at once, zero
+	// keeps the periods fixed
+	LoopJitter float64 `json:"loopJitter,omitempty"`
+	// ResponseHeaders are set on every response, over the security
+	// headers of defaultResponseHeaders, an empty value drops one of them
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	// MaxConcurrentRequests caps the requests handled at once, more are
+	// answered 503 at once, zero means no limit. /ping, /cache/status
+	// and /metrics are never limited, the streams of /jobs/watch are
+	// capped apart by the same number.
+	MaxConcurrentRequests int `json:"maxConcurrentRequests,omitempty"`
+	// StateConfigMap is the ConfigMap in the namespace of the jobs the
+	// worker registry, i.e. the heartbeats missed and the mirrors set
+	// offline, is saved to and loaded from on start, so that a restart
+	// doesn't lose it. Nothing is saved when empty.
+	StateConfigMap string `json:"stateConfigMap,omitempty"`
+	// DebugPanics responds to a panic of a handler with its stack, and
+	// logs it at error level, for development only as it exposes the
+	// internals of the manager
+	DebugPanics bool `json:"debugPanics,omitempty"`
+	// Defaults are the specs new mirrors inherit by type, what's posted
+	// on creation is merged over it field by field
+	Defaults map[v1beta1.MirrorType]v1beta1.JobSpec `json:"defaults,omitempty"`
+	// DefaultInterval is the interval in minutes of mirrors setting none,
+	// 1440 by default, a value set at runtime is kept in StateConfigMap
+	DefaultInterval int `json:"defaultInterval,omitempty"`
+	// UnknownStatus is what's done to a status reported which isn't
+	// known, reject by default or coerce, see UnknownStatusCoerce
+	UnknownStatus string `json:"unknownStatus,omitempty"`
+	// StatusConflict is how a status reported concurrently with another
+	// write is resolved, retry by default or timestamp, see
+	// StatusConflictTimestamp
+	StatusConflict string `json:"statusConflict,omitempty"`
+	// ReloadConfigMap is a ConfigMap watched for options to change at
+	// runtime, e.g. timeouts or the admin token, see reloadableOptions
+	ReloadConfigMap string `json:"reloadConfigMap,omitempty"`
 }
 
 type Manager struct {
 	engine     *gin.Engine
 	httpClient *http.Client
-	client     client.Client
+	cmdClient  *http.Client
+	client     Store
 	started    bool
 	internal   context.Context
 	cache      cache.Cache
 	address    string
 	rwmu       sync.RWMutex
-	option     *Options
+	// option is swapped whole when reloaded, see options
+	option atomic.Pointer[Options]
+	// ready is set once the cache has synced, see requireCache
+	ready atomic.Bool
+
+	idempotency *idempotencyCache
+	metrics     *jobMetrics
+	cacheState  *cacheState
+	maintenance maintenanceWindow
+	scheduling  schedulingState
+	tracing     *sdktrace.TracerProvider
+	auditLog    *auditLogger
+	heartbeats  *heartbeats
+	nonces      *nonceCache
+	// apiReader reads past the cache of client, for reads which must
+	// see the latest writes
+	apiReader client.Reader
+	// namespace of the jobs, namespaceReady is set once it's known to
+	// exist, see ensureNamespace
+	namespace      string
+	namespaceReady atomic.Bool
+	// namespaceForbidden is set when the manager may not create the
+	// namespace, which may be missing then
+	namespaceForbidden atomic.Bool
+	// inFlight holds a slot per request being handled, and streams per
+	// stream open, nil when unlimited
+	inFlight   chan struct{}
+	streams    chan struct{}
+	stateSaver stateSaver
+	// defaultInterval is Options.DefaultInterval until set at runtime
+	defaultInterval globalInterval
+	draining        drainingNodes
+	// clock stamps the status of the mirrors, realClock but in tests
+	clock   Clock
+	markers markerCache
 }
 
 func contextErrorLogger(c *gin.Context) {
@@ -86,97 +259,292 @@ func contextErrorLogger(c *gin.Context) {
 }
 
 func GetTUNASyncManager(config *rest.Config, options Options) (*Manager, error) {
-	namespace := os.Getenv("NAMESPACE")
-	if namespace == "" {
-		return nil, errors.New("can't get namespace")
+	if err := setupLogger(options.LogLevel, options.LogFormat); err != nil {
+		return nil, err
 	}
 
-	rhc, err := rest.HTTPClientFor(config)
-	if err != nil {
-		return nil, err
+	// no pod to take the namespace from
+	if options.Storage == StorageMemory && strings.TrimSpace(options.Namespace) == "" {
+		options.Namespace = memoryNamespace
 	}
-	mapper, err := apiutil.NewDynamicRESTMapper(config, rhc)
+	namespace, err := resolveNamespace(options.Namespace)
 	if err != nil {
 		return nil, err
 	}
+	runLog.Info("Serving jobs in namespace " + namespace)
 
-	cc, err := cache.New(config, cache.Options{
-		Scheme:            options.Scheme,
-		Mapper:            mapper,
-		SyncPeriod:        &defaultRetryPeriod,
-		DefaultNamespaces: map[string]cache.Config{namespace: {}},
-	})
-
-	c, err := client.New(config, client.Options{Scheme: options.Scheme, Mapper: mapper, Cache: &client.CacheOptions{Reader: cc}})
-	if err != nil {
+	if err = validateDefaults(options.Defaults); err != nil {
+		return nil, err
+	}
+	if options.DefaultInterval == 0 {
+		options.DefaultInterval = defaultInterval
+	}
+	if err = validateInterval(options.DefaultInterval); err != nil {
+		return nil, fmt.Errorf("invalid default interval: %w", err)
+	}
+	if err = validateConflictOptions(&options); err != nil {
+		return nil, err
+	}
+	if err = validateSignedCmds(&options); err != nil {
 		return nil, err
 	}
+	if options.LoopJitter < 0 || options.LoopJitter > 1 {
+		return nil, fmt.Errorf("invalid loop jitter %v, must be within 0-1", options.LoopJitter)
+	}
+
+	// direct reads the api server past the cache, the same as c unless
+	// c reads a cache
+	c, cc := options.Client, options.Cache
+	direct := c
+	switch {
+	case c != nil:
+		if cc == nil {
+			return nil, errors.New("cache required along with the injected client")
+		}
+	case options.Storage == StorageMemory:
+		c, cc = newMemoryStore(options.Scheme)
+		direct = c
+		runLog.Info("Keeping jobs in memory, they are lost on restart")
+	case options.Storage == "", options.Storage == StorageKubernetes:
+		if c, direct, cc, err = newClient(config, options.Scheme, namespace, jittered(defaultRetryPeriod, options.LoopJitter)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid storage %s", options.Storage)
+	}
 
 	nc := client.NewNamespacedClient(c, namespace)
 
+	if options.HistoryLimit <= 0 {
+		options.HistoryLimit = defaultHistoryLimit
+	}
+	if options.MaxBodyBytes <= 0 {
+		options.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if options.Address == "" {
+		options.Address = defaultAddress
+	}
+	if err = validateAddress(options.Address); err != nil {
+		return nil, err
+	}
+	if options.ReadTimeout.Duration <= 0 {
+		options.ReadTimeout.Duration = defaultServerTimeout
+	}
+	if options.WriteTimeout.Duration <= 0 {
+		options.WriteTimeout.Duration = defaultServerTimeout
+	}
+	if options.CmdTimeout.Duration <= 0 {
+		options.CmdTimeout.Duration = defaultCmdTimeout
+	}
+	if options.OfflineAfterMisses <= 0 {
+		options.OfflineAfterMisses = defaultOfflineAfterMisses
+	}
+
 	hc := &http.Client{
 		Transport: &http.Transport{MaxIdleConnsPerHost: 100},
 		Timeout:   5 * time.Second,
 	}
+	// shares the connections of hc
+	cmdc := &http.Client{
+		Transport: hc.Transport,
+		Timeout:   options.CmdTimeout.Duration,
+	}
 
 	s := &Manager{
 		httpClient: hc,
-		client:     nc,
+		cmdClient:  cmdc,
+		client:     debugClient{nc},
+		apiReader:  client.NewNamespacedClient(direct, namespace),
+		namespace:  namespace,
 		internal:   context.Background(),
 		cache:      cc,
 		address:    options.Address,
-		option:     &options,
+
+		idempotency: newIdempotencyCache(idempotencyTTL, idempotencySize),
+		heartbeats:  newHeartbeats(),
+		nonces:      newNonceCache(),
+		clock:       realClock{},
+	}
+	s.option.Store(&options)
+	// the namespace of the pod exists as long as the manager runs
+	if namespace == podNamespace() {
+		s.namespaceReady.Store(true)
 	}
+	s.metrics = newJobMetrics(s.now)
+	s.cacheState = newCacheState(s.metrics.registry)
+	s.defaultInterval.set(options.DefaultInterval)
 
 	gin.SetMode(gin.ReleaseMode)
 
 	s.engine = gin.New()
-	s.engine.Use(gin.Recovery())
+	// gin trusts every proxy unless told otherwise
+	if err = s.engine.SetTrustedProxies(options.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	// /jobs/ redirects to /jobs and the other way round, a path of
+	// another case or with extra slashes is not found
+	s.engine.RedirectTrailingSlash = true
+	s.engine.RedirectFixedPath = false
+	s.engine.NoRoute(s.noRoute)
+	if options.DebugPanics {
+		s.engine.Use(debugRecovery())
+	} else {
+		s.engine.Use(gin.Recovery())
+	}
+	s.engine.Use(setHeaders(responseHeaders(options.ResponseHeaders)))
+	s.engine.Use(s.requireCache(unlimitedPaths(options.BasePath)))
+	if options.MaxConcurrentRequests > 0 {
+		s.inFlight = make(chan struct{}, options.MaxConcurrentRequests)
+		s.streams = make(chan struct{}, options.MaxConcurrentRequests)
+		s.engine.Use(s.limitInFlight(unlimitedPaths(options.BasePath), streamingPaths(options.BasePath)))
+	}
+
+	if options.TracingEndpoint != "" {
+		if s.tracing, err = setupTracing(s.internal, options.TracingEndpoint); err != nil {
+			return nil, err
+		}
+		tracer := s.tracing.Tracer(tracerName)
+		s.client = tracingClient{s.client, tracer}
+		s.engine.Use(traceRequest(tracer))
+		runLog.Info("Exporting request traces to " + options.TracingEndpoint)
+	}
 
 	// common log middleware
 	s.engine.Use(contextErrorLogger)
+	if options.ReadOnly {
+		s.engine.Use(s.rejectWrites)
+	}
+	s.engine.Use(s.limitBody)
+	s.engine.Use(s.requireJSON)
+	if options.LogBodies {
+		s.engine.Use(logBody)
+	}
+
+	if options.AuditLog != "" {
+		if s.auditLog, err = newAuditLogger(options.AuditLog); err != nil {
+			return nil, err
+		}
+		s.client = auditClient{s.client}
+		s.engine.Use(s.audit)
+	}
+
+	// all routes live under the base path, "/" by default
+	router := s.engine.Group(options.BasePath)
 
-	s.engine.GET("/ping", func(c *gin.Context) {
+	router.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{_infoKey: "pong"})
 	})
 
+	router.GET("/version", getVersion)
+	// commands accepted by /job/:id/cmd
+	router.GET("/commands", listCommands)
+	// what this manager supports, checked by workers at startup
+	router.GET("/capabilities", s.getCapabilities)
+	// the spec new mirrors of a type inherit
+	router.GET("/defaults/:type", s.getDefaults)
+	// the interval of mirrors setting none, workers ask for it
+	router.GET("/config/default-interval", s.getDefaultInterval)
+	router.PUT("/config/default-interval", s.requireAdmin, s.setDefaultInterval)
+	// move the mirrors off a node being decommissioned
+	router.POST("/workers/:id/drain", s.requireAdmin, s.drainWorker)
+	router.DELETE("/workers/:id/drain", s.requireAdmin, s.undrainWorker)
+	router.GET("/cache/status", s.getCacheStatus)
+	router.GET("/debug/state", s.requireAdmin, s.getDebugState)
+
+	// freeze syncs for a while
+	router.GET("/maintenance", s.getMaintenance)
+	router.POST("/maintenance", s.requireAdmin, s.setMaintenance)
+	router.DELETE("/maintenance", s.requireAdmin, s.clearMaintenance)
+	// pause the start of new syncs on all mirrors during an incident
+	router.GET("/scheduling", s.getScheduling)
+	router.POST("/scheduling/pause", s.requireAdmin, s.pauseScheduling)
+	router.POST("/scheduling/resume", s.requireAdmin, s.resumeScheduling)
+
+	router.GET("/metrics", gin.WrapH(promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})))
+
 	// list jobs, status page
-	s.engine.GET("/jobs", s.listJob)
-	s.engine.GET("/api/mirrors", s.listJob)
+	router.GET("/jobs", s.maintenanceInfo, gzipCompress, s.listJob)
+	router.GET("/api/mirrors", s.maintenanceInfo, gzipCompress, s.listJob)
+	// stream status changes of jobs
+	router.GET("/jobs/watch", s.watchJob)
+	// mirrors overdue for sync
+	router.GET("/jobs/stale", s.listStaleJob)
+	// mirrors not synced successfully since created
+	router.GET("/jobs/never-synced", s.listNeverSyncedJob)
+	// counts by status for the dashboard
+	router.GET("/jobs/summary", s.summarizeJob)
+	// the jobs every mirror waits for
+	router.GET("/jobs/dependencies", s.listDependencies)
+	// whether a mirror exists, cheaper than the GET of /job/:id
+	router.HEAD("/jobs/:id", s.resolveAlias, s.headJob)
+	// start or restart all mirrors of a type
+	router.POST("/jobs/cmd", s.verifySignature, s.idempotent, s.handleBulkCmd)
+	// delete mirrors by ids or selector
+	router.DELETE("/jobs", s.requireAdmin, s.deleteJobs)
+
+	// backup and restore the specs of all jobs
+	router.GET("/export", s.exportJob)
+	router.POST("/import", s.requireAdmin, s.importJob)
 
 	if options.MirrorZ != nil {
-		s.engine.GET("/api/mirrorz.json", s.mirrorZ)
+		router.GET("/api/mirrorz.json", gzipCompress, s.mirrorZ)
 	}
 
 	// mirrorID should be valid in this route group
-	mirrorValidateGroup := s.engine.Group("/job/:id")
+	mirrorValidateGroup := router.Group("/job/:id", s.validMirrorID)
 	{
 		// delete specified mirror
-		mirrorValidateGroup.DELETE("", s.deleteJob)
-		// get job detail
-		mirrorValidateGroup.GET("", s.getJob)
-		mirrorValidateGroup.GET("config", s.getJobConfig)
-		mirrorValidateGroup.GET("log", s.getJobLatestLog)
+		mirrorValidateGroup.DELETE("", s.requireAdmin, s.deleteJob)
+		// get job detail, by name or alias
+		mirrorValidateGroup.GET("", s.resolveAlias, s.getJob)
+		mirrorValidateGroup.GET("config", s.resolveAlias, s.getJobConfig)
+		// config with the defaults the worker falls back to filled in
+		mirrorValidateGroup.GET("effective-config", s.resolveAlias, s.getEffectiveConfig)
+		mirrorValidateGroup.GET("log", s.resolveAlias, s.getJobLatestLog)
+		mirrorValidateGroup.GET("history", s.resolveAlias, s.getJobHistory)
+		mirrorValidateGroup.GET("size-trend", s.resolveAlias, s.getJobSizeTrend)
+		// kubernetes events of the job, latest first
+		mirrorValidateGroup.GET("events", s.resolveAlias, s.getJobEvents)
+		// how far the mirror lags behind its upstream marker
+		mirrorValidateGroup.GET("lag", s.requireAdmin, s.resolveAlias, s.getJobLag)
+		// 409 while the jobs the mirror depends on haven't synced
+		mirrorValidateGroup.GET("dependencies", s.resolveAlias, s.getJobDependencies)
 		// create or patch job
 		mirrorValidateGroup.POST("", s.createJob)
 		// mirror online
-		mirrorValidateGroup.HEAD("", s.registerMirror)
+		mirrorValidateGroup.HEAD("", s.requireWorker, s.registerMirror)
 		// post job status
-		mirrorValidateGroup.PATCH("", s.updateJob)
-		mirrorValidateGroup.POST("size", s.updateMirrorSize)
-		mirrorValidateGroup.POST("schedule", s.updateSchedule)
+		mirrorValidateGroup.PATCH("", s.requireWorker, s.updateJob)
+		mirrorValidateGroup.POST("size", s.requireWorker, s.updateMirrorSize)
+		mirrorValidateGroup.GET("schedule", s.resolveAlias, s.getSchedule)
+		mirrorValidateGroup.POST("schedule", s.requireWorker, s.updateSchedule)
 		mirrorValidateGroup.POST("enable", s.enableJob)
 		mirrorValidateGroup.POST("disable", s.disableJob)
+		// sync more often for a while, e.g. during an upstream release
+		mirrorValidateGroup.POST("boost", s.boostJob)
+		mirrorValidateGroup.DELETE("boost", s.unboostJob)
+		// worker shutting down cleanly
+		mirrorValidateGroup.POST("offline", s.requireWorker, s.offlineJob)
+		// issue a new worker token
+		mirrorValidateGroup.POST("token", s.requireAdmin, s.rotateToken)
+		mirrorValidateGroup.POST("note", s.updateNote)
+		// operational metadata, e.g. owner team or ticket links
+		mirrorValidateGroup.GET("annotations", s.resolveAlias, s.getJobAnnotations)
+		mirrorValidateGroup.PUT("annotations", s.updateJobAnnotations)
+		// copy the spec to a new mirror
+		mirrorValidateGroup.POST("clone", s.resolveAlias, s.cloneJob)
+		// set status directly, for recovery only
+		mirrorValidateGroup.POST("status", s.requireAdmin, s.forceStatus)
 		// for tunasynctl to post commands
-		mirrorValidateGroup.POST("cmd", s.handleClientCmd)
+		mirrorValidateGroup.POST("cmd", s.resolveAlias, s.verifySignature, s.idempotent, s.handleClientCmd)
 	}
 
 	// list announcements
-	s.engine.GET("/announcements", s.listAnnouncement)
-	s.engine.GET("/api/news", s.listAnnouncement)
+	router.GET("/announcements", gzipCompress, s.listAnnouncement)
+	router.GET("/api/news", gzipCompress, s.listAnnouncement)
 
 	// announcementID should be valid in this route group
-	announcementValidateGroup := s.engine.Group("/announcement/:id")
+	announcementValidateGroup := router.Group("/announcement/:id")
 	{
 		// create or patch announcement
 		announcementValidateGroup.POST("", s.createAnnouncement)
@@ -187,11 +555,11 @@ func GetTUNASyncManager(config *rest.Config, options Options) (*Manager, error)
 	}
 
 	// list files
-	s.engine.GET("/files", s.listFile)
-	s.engine.GET("/api/files", s.listFile)
+	router.GET("/files", gzipCompress, s.listFile)
+	router.GET("/api/files", gzipCompress, s.listFile)
 
 	// fileID should be valid in this route group
-	fileValidateGroup := s.engine.Group("/file/:id")
+	fileValidateGroup := router.Group("/file/:id")
 	{
 		// create or patch file
 		fileValidateGroup.POST("", s.updateFile)
@@ -204,18 +572,94 @@ func GetTUNASyncManager(config *rest.Config, options Options) (*Manager, error)
 	return s, nil
 }
 
-func (m *Manager) Start(ctx context.Context) error {
-	m.waitForCache()
+// newClient connects to the api server, it returns a client whose reads
+// are served by the returned cache of the jobs in namespace, and one
+// reading the api server directly
+func newClient(config *rest.Config, scheme *runtime.Scheme, namespace string, resync time.Duration) (client.Client, client.Client, cache.Cache, error) {
+	rhc, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mapper, err := apiutil.NewDynamicRESTMapper(config, rhc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cc, err := cache.New(config, cache.Options{
+		Scheme:            scheme,
+		Mapper:            mapper,
+		SyncPeriod:        &resync,
+		DefaultNamespaces: map[string]cache.Config{namespace: {}},
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	c, err := client.New(config, client.Options{Scheme: scheme, Mapper: mapper, Cache: &client.CacheOptions{Reader: cc}})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	direct, err := client.New(config, client.Options{Scheme: scheme, Mapper: mapper})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return c, direct, cc, nil
+}
+
+// resolveNamespace falls back to the namespace of the service account
+// the pod runs as
+func resolveNamespace(namespace string) (string, error) {
+	namespace = strings.TrimSpace(namespace)
+	if namespace == "" {
+		if namespace = podNamespace(); namespace == "" {
+			return "", fmt.Errorf("can't get namespace, set NAMESPACE or run in a pod with %s", serviceAccountNamespace)
+		}
+	}
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return "", fmt.Errorf("invalid namespace %s: %s", namespace, strings.Join(errs, ", "))
+	}
+	return namespace, nil
+}
+
+// validateAddress checks the address to listen, port 0 picks a random
+// port which is logged once listening
+func validateAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %s", address, err.Error())
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid address %s: port must be within 0-65535", address)
+	}
+	return nil
+}
 
+func (m *Manager) Start(ctx context.Context) error {
 	runLog.Info("Tunasync manager server is starting to listen " + m.address)
 
+	// listen before the cache syncs, so that /cache/status can tell
+	// a stuck sync, other requests are answered 503 meanwhile
 	go func() {
 		if err := m.Run(m.internal); err != nil {
 			panic(err)
 		}
 	}()
+	m.waitForCache()
+	go m.collectGarbage(ctx)
+	m.watchConfig(ctx)
+	if !m.options().ReadOnly {
+		go m.watchHeartbeats(ctx)
+		go m.watchBoosts(ctx)
+	}
 	select {
 	case <-ctx.Done():
+		// ctx is done, the state is saved within the grace period
+		m.saveState(context.Background())
+		if m.tracing != nil {
+			// flush the spans still batched
+			return m.tracing.Shutdown(context.Background())
+		}
 		return nil
 	}
 }
@@ -225,6 +669,19 @@ func (m *Manager) waitForCache() {
 		return
 	}
 
+	informer, err := m.cache.GetInformer(m.internal, &v1beta1.Job{})
+	if err != nil {
+		panic(err)
+	}
+	if _, err = informer.AddEventHandler(m.metrics.handler()); err != nil {
+		panic(err)
+	}
+	if _, err = informer.AddEventHandler(m.cacheState.handler()); err != nil {
+		panic(err)
+	}
+	m.cacheState.informer.Store(&informer)
+	m.cacheState.started.Store(time.Now().Unix())
+
 	go func() {
 		if err := m.cache.Start(m.internal); err != nil {
 			panic(err)
@@ -232,8 +689,22 @@ func (m *Manager) waitForCache() {
 	}()
 
 	// Wait for the caches to sync.
-	m.cache.WaitForCacheSync(m.internal)
+	runLog.V(1).Info("Waiting for cache to sync")
+	start := time.Now()
+	synced := m.cache.WaitForCacheSync(m.internal)
+	if synced {
+		m.cacheState.syncedAt.Store(time.Now().Unix())
+	}
+	runLog.V(1).Info("Cache sync finished", "synced", synced, "duration", time.Since(start).String())
+	m.loadState(m.internal)
 	m.started = true
+	m.ready.Store(true)
+}
+
+// Handler serves the routes of the manager, for testing with httptest
+// without listening
+func (m *Manager) Handler() http.Handler {
+	return m.engine
 }
 
 // Run runs the manager server forever
@@ -241,13 +712,26 @@ func (m *Manager) Run(ctx context.Context) error {
 	httpServer := &http.Server{
 		Addr:         m.address,
 		Handler:      m.engine,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  m.options().ReadTimeout.Duration,
+		WriteTimeout: m.options().WriteTimeout.Duration,
+	}
+
+	ln, err := net.Listen("tcp", m.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen %s: %w", m.address, err)
+	}
+	if addr := ln.Addr().String(); addr != m.address {
+		runLog.Info("Tunasync manager server is listening " + addr)
 	}
 
 	go func() {
-		err := httpServer.ListenAndServe()
-		if err != nil {
+		var err error
+		if m.options().TLSCertFile != "" && m.options().TLSKeyFile != "" {
+			err = httpServer.ServeTLS(ln, m.options().TLSCertFile, m.options().TLSKeyFile)
+		} else {
+			err = httpServer.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			panic(err)
 		}
 	}()
@@ -262,8 +746,8 @@ func (m *Manager) GetJob(c *gin.Context, mirrorID string) (*v1beta1.Job, error)
 	job := new(v1beta1.Job)
 	err := m.client.Get(c.Request.Context(), client.ObjectKey{Name: mirrorID}, job)
 	if err != nil {
-		err := fmt.Errorf("failed to get mirror: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to get mirror: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -323,10 +807,27 @@ func (m *Manager) createJob(c *gin.Context) {
 		},
 	}
 	if err := m.client.Get(c.Request.Context(), client.ObjectKey{Name: mirrorID}, ojb); err != nil || ojb == nil {
-		var jobSpec v1beta1.JobSpec
-		c.BindJSON(&jobSpec)
-		job.Spec = jobSpec
+		if !m.ifMatch(c, mirrorID, nil) {
+			return
+		}
+		jobSpec := make(map[string]map[string]interface{})
+		if !m.bindJSON(c, &jobSpec) || !m.rejectTokenHash(c, jobSpec) {
+			return
+		}
+		// a new mirror inherits the defaults of its type
+		merged := m.withDefaults(c, jobSpec)
+		if merged == nil {
+			return
+		}
+		// the interval is left unset for the worker to ask the default
+		// at each sync, which follows /config/default-interval
+		job.Spec = *merged
 	} else {
+		if !m.ifMatch(c, mirrorID, ojb) {
+			return
+		}
+		// the api server rejects the apply when the version changed since
+		job.ResourceVersion = ifMatchVersion(c)
 		oJobBytes, err := json.Marshal(ojb.Spec)
 		if err != nil {
 			c.AbortWithError(http.StatusBadRequest, err)
@@ -338,14 +839,34 @@ func (m *Manager) createJob(c *gin.Context) {
 			return
 		}
 		jobSpec := make(map[string]map[string]interface{})
-		c.BindJSON(&jobSpec)
-		job.Spec = *handleMerge(c, &oJobSpec, &jobSpec)
+		if !m.bindJSON(c, &jobSpec) || !m.rejectTokenHash(c, jobSpec) {
+			return
+		}
+		merged := handleMerge(c, &oJobSpec, &jobSpec)
+		if merged == nil {
+			return
+		}
+		job.Spec = *merged
+	}
+
+	errs := validateJobSpec(&job.Spec)
+	errs = append(errs, m.validateAlias(c.Request.Context(), mirrorID, job.Spec.Config.Alias)...)
+	errs = append(errs, m.validateDependsOn(c.Request.Context(), mirrorID, job.Spec.Config.DependsOn, nil)...)
+	errs = append(errs, m.validateNodeName(&job.Spec, ojb.Spec.Deploy.NodeName)...)
+	if len(errs) > 0 {
+		err := fmt.Errorf("invalid job %s: %s", mirrorID, errs.ToAggregate().Error())
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{_errorKey: err.Error(), _codeKey: internal.ErrInvalid, "fields": toFieldErrors(errs)})
+		return
+	}
+
+	if e = m.ensureNamespace(c.Request.Context()); e == nil {
+		e = m.client.Patch(c.Request.Context(), &job, client.Apply, []client.PatchOption{client.ForceOwnership, client.FieldOwner("mirror-controller")}...)
 	}
-	e = m.client.Patch(c.Request.Context(), &job, client.Apply, []client.PatchOption{client.ForceOwnership, client.FieldOwner("mirror-controller")}...)
 
 	if e != nil {
-		err := fmt.Errorf("failed to patch job %s: %s",
-			mirrorID, e.Error(),
+		err := fmt.Errorf("failed to patch job %s: %w",
+			mirrorID, m.namespaceError(e),
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -354,113 +875,502 @@ func (m *Manager) createJob(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{_infoKey: "patch " + mirrorID + " succeed"})
 }
 
-// listJob respond with all jobs of specified mirrors
+// publicURL is where users download from, falling back to the
+// deprecated Url
+func publicURL(cfg *v1beta1.JobConfig) string {
+	if cfg.PublicURL != "" {
+		return cfg.PublicURL
+	}
+	return cfg.Url
+}
+
+// mirrorStatus converts a non-external job to its listing entry, with
+// the uptime of its worker at now
+func mirrorStatus(v *v1beta1.Job, now time.Time) internal.MirrorStatus {
+	w := internal.MirrorStatus{
+		ID:        v.Name,
+		Alias:     v.Spec.Config.Alias,
+		Desc:      v.Spec.Config.Desc,
+		Url:       publicURL(&v.Spec.Config),
+		HelpUrl:   v.Spec.Config.HelpUrl,
+		Type:      v.Spec.Config.Type,
+		SizeStr:   internal.ParseSize(v.Status.Size),
+		Note:      v.Spec.Config.Note,
+		JobStatus: v.Status,
+	}
+	w.PublicURL = w.Url
+	if v.Status.DiskSize > 0 {
+		w.DiskSizeStr = internal.ParseSize(v.Status.DiskSize)
+	}
+	if v.Status.CompressedSize > 0 {
+		w.CompressedSizeStr = internal.ParseSize(v.Status.CompressedSize)
+	}
+	w.ResourceVersion = v.ResourceVersion
+	w.UpstreamURL = v.Spec.Config.Upstream
+	if !v.CreationTimestamp.IsZero() {
+		w.Created = v.CreationTimestamp.Unix()
+	}
+	w.Owner = v.Spec.Config.Owner
+	w.Contact = v.Spec.Config.Contact
+	// history and log are only served by /job/:id/history and /job/:id/log
+	w.History = nil
+	w.LogTail = ""
+	w.TokenHash = ""
+	if v.Status.LastRegister != 0 && v.Status.Status != v1beta1.Offline {
+		w.Uptime = now.Unix() - v.Status.LastRegister
+	}
+	switch v.Spec.Config.Type {
+	case v1beta1.Proxy:
+		w.Upstream = v.Spec.Config.Upstream
+		w.Status = v1beta1.Cached
+	case v1beta1.Git:
+		w.Upstream = v.Spec.Config.Upstream
+		w.Status = v1beta1.Created
+	case "":
+		w.Type = v1beta1.Mirror
+	}
+	return w
+}
+
+// listOptions passes ?labelSelector through to the list, e.g. tier=public
+func (m *Manager) listOptions(c *gin.Context) ([]client.ListOption, bool) {
+	selector := c.Query("labelSelector")
+	if selector == "" {
+		return nil, true
+	}
+	s, err := labels.Parse(selector)
+	if err != nil {
+		err := fmt.Errorf("invalid labelSelector %s: %w", selector, err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return []client.ListOption{client.MatchingLabelsSelector{Selector: s}}, true
+}
+
+// statusFilter keeps the mirrors in one of statuses, if any, whose
+// LastUpdate and LastEnded fall in the windows, each a half-open
+// [after, before) range in unix seconds with zero meaning unbounded
+type statusFilter struct {
+	statuses     map[v1beta1.SyncStatus]bool
+	updateAfter  int64
+	updateBefore int64
+	endedAfter   int64
+	endedBefore  int64
+}
+
+// parseStatusFilter reads ?status, a comma separated list, along with
+// ?lastUpdateAfter, ?lastUpdateBefore, ?lastEndedAfter and ?lastEndedBefore
+func (m *Manager) parseStatusFilter(c *gin.Context) (statusFilter, bool) {
+	var f statusFilter
+	if v := c.Query("status"); v != "" {
+		f.statuses = make(map[v1beta1.SyncStatus]bool)
+		for _, s := range strings.Split(v, ",") {
+			status := v1beta1.SyncStatus(strings.TrimSpace(s))
+			if !internal.IsSyncStatus(status) {
+				err := fmt.Errorf("unknown status: %s", status)
+				c.Error(err)
+				m.returnErrJSON(c, http.StatusBadRequest, err)
+				return f, false
+			}
+			f.statuses[status] = true
+		}
+	}
+
+	bounds := []struct {
+		key string
+		p   *int64
+	}{
+		{"lastUpdateAfter", &f.updateAfter},
+		{"lastUpdateBefore", &f.updateBefore},
+		{"lastEndedAfter", &f.endedAfter},
+		{"lastEndedBefore", &f.endedBefore},
+	}
+	for _, b := range bounds {
+		v := c.Query(b.key)
+		if v == "" {
+			continue
+		}
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || t <= 0 {
+			err := fmt.Errorf("invalid %s %s", b.key, v)
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusBadRequest, err)
+			return f, false
+		}
+		*b.p = t
+	}
+	return f, true
+}
+
+func inWindow(t, after, before int64) bool {
+	return (after == 0 || t >= after) && (before == 0 || t < before)
+}
+
+func (f statusFilter) match(w *internal.MirrorStatus) bool {
+	if f.statuses != nil && !f.statuses[w.Status] {
+		return false
+	}
+	return inWindow(w.LastUpdate, f.updateAfter, f.updateBefore) &&
+		inWindow(w.LastEnded, f.endedAfter, f.endedBefore)
+}
+
+// listJob respond with all jobs of specified mirrors, sorted by id or,
+// with ?sort=created, oldest first, ?owner only keeps the mirrors of a team.
+// ?status and the time windows of parseStatusFilter narrow them further,
+// e.g. the mirrors which succeeded last week.
+// The jobs are read from the cache, which may miss a write just made,
+// ?consistent=true reads the api server instead at the cost of a round
+// trip and load on it.
 func (m *Manager) listJob(c *gin.Context) {
 	var ws []internal.MirrorStatus
 
+	byCreated := false
+	switch order := c.DefaultQuery("sort", "id"); order {
+	case "id":
+	case "created":
+		byCreated = true
+	default:
+		err := fmt.Errorf("invalid sort %s", order)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	opts, ok := m.listOptions(c)
+	if !ok {
+		return
+	}
+	loc, ok := m.timeFormat(c)
+	if !ok {
+		return
+	}
+	filter, ok := m.parseStatusFilter(c)
+	if !ok {
+		return
+	}
+
+	var reader client.Reader = m.client
+	if consistent, _ := strconv.ParseBool(c.Query("consistent")); consistent {
+		reader = m.apiReader
+	}
+
 	m.rwmu.RLock()
 	defer m.rwmu.RUnlock()
 	jobs := new(v1beta1.JobList)
-	err := m.client.List(c.Request.Context(), jobs)
+	err := reader.List(c.Request.Context(), jobs, opts...)
+	now := m.now()
 
+	owner := c.Query("owner")
 	for _, v := range jobs.Items {
+		if owner != "" && v.Spec.Config.Owner != owner {
+			continue
+		}
 		if v.Spec.Config.Type == v1beta1.External {
-			wss, _ := external.Provider(&v.Spec.Config, m.httpClient).List()
-			ws = append(ws, wss...)
-		} else {
-			w := internal.MirrorStatus{
-				ID:        v.Name,
-				Alias:     v.Spec.Config.Alias,
-				Desc:      v.Spec.Config.Desc,
-				Url:       v.Spec.Config.Url,
-				HelpUrl:   v.Spec.Config.HelpUrl,
-				Type:      v.Spec.Config.Type,
-				SizeStr:   internal.ParseSize(v.Status.Size),
-				JobStatus: v.Status,
+			wss, lerr := external.Provider(&v.Spec.Config, m.httpClient).List()
+			if lerr != nil {
+				// the other mirrors are still listed
+				addWarning(c, fmt.Sprintf("failed to list the external mirrors of %s: %s", v.Name, lerr.Error()))
 			}
-			switch v.Spec.Config.Type {
-			case v1beta1.Proxy:
-				w.Upstream = v.Spec.Config.Upstream
-				w.Status = v1beta1.Cached
-			case v1beta1.Git:
-				w.Upstream = v.Spec.Config.Upstream
-				w.Status = v1beta1.Created
-			case "":
-				w.Type = v1beta1.Mirror
+			for i := range wss {
+				if filter.match(&wss[i]) {
+					ws = append(ws, wss[i])
+				}
 			}
+		} else if w := mirrorStatus(&v, now); filter.match(&w) {
 			ws = append(ws, w)
 		}
 	}
 
+	if err != nil {
+		err := fmt.Errorf("failed to list mirrors: %w",
+			err,
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
 	sort.Slice(ws, func(i, j int) bool {
+		if byCreated && ws[i].Created != ws[j].Created {
+			return ws[i].Created < ws[j].Created
+		}
 		return strings.ToLower(ws[i].ID) < strings.ToLower(ws[j].ID)
 	})
 
-	if err != nil {
-		err := fmt.Errorf("failed to list mirrors: %s",
-			err.Error(),
+	// e.g. ?compat=tunasync for the consumers of a tunasync manager
+	if m.respondCompat(c, ws) {
+		return
+	}
+
+	// only return the requested fields, e.g. ?fields=id,status,size
+	if fields := c.Query("fields"); fields != "" {
+		projected, err := projectFields(ws, strings.Split(fields, ","))
+		if err != nil {
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusBadRequest, err)
+			return
+		}
+		m.respondTimes(c, projected, loc)
+		return
+	}
+	m.respondTimes(c, ws, loc)
+}
+
+// listStaleJob respond with the mirrors not updated within ?threshold,
+// most stale first, disabled mirrors are left out
+func (m *Manager) listStaleJob(c *gin.Context) {
+	threshold, err := time.ParseDuration(c.DefaultQuery("threshold", defaultStaleThreshold))
+	if err != nil || threshold <= 0 {
+		err := fmt.Errorf("invalid threshold %s", c.Query("threshold"))
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	opts, ok := m.listOptions(c)
+	if !ok {
+		return
+	}
+	loc, ok := m.timeFormat(c)
+	if !ok {
+		return
+	}
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	jobs := new(v1beta1.JobList)
+	if err = m.client.List(c.Request.Context(), jobs, opts...); err != nil {
+		err := fmt.Errorf("failed to list mirrors: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
-	c.JSON(http.StatusOK, ws)
+
+	now := m.now()
+	ws := []internal.StaleMirror{}
+	for _, v := range jobs.Items {
+		if w, stale := staleMirror(&v, now, threshold); stale {
+			ws = append(ws, w)
+		}
+	}
+
+	sort.Slice(ws, func(i, j int) bool {
+		if ws[i].NeverSynced != ws[j].NeverSynced {
+			return ws[i].NeverSynced
+		}
+		return ws[i].Staleness > ws[j].Staleness
+	})
+	m.respondTimes(c, ws, loc)
 }
 
-func (m *Manager) getJob(c *gin.Context) {
-	mirrorID := c.Param("id")
+// listNeverSyncedJob responds with the mirrors which never synced
+// successfully, longest waiting first, e.g. misconfigured new mirrors
+func (m *Manager) listNeverSyncedJob(c *gin.Context) {
+	opts, ok := m.listOptions(c)
+	if !ok {
+		return
+	}
+	loc, ok := m.timeFormat(c)
+	if !ok {
+		return
+	}
 
 	m.rwmu.RLock()
 	defer m.rwmu.RUnlock()
-
-	job, err := m.GetJob(c, mirrorID)
-	if err != nil {
-		err := fmt.Errorf("failed to get job %s: %s",
-			mirrorID, err.Error(),
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(c.Request.Context(), jobs, opts...); err != nil {
+		err := fmt.Errorf("failed to list mirrors: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
-	c.JSON(http.StatusOK, job.Status)
+
+	now := m.now()
+	ws := []internal.StaleMirror{}
+	for _, v := range jobs.Items {
+		// a never synced mirror is stale whatever the threshold
+		if w, stale := staleMirror(&v, now, 0); stale && w.NeverSynced {
+			ws = append(ws, w)
+		}
+	}
+
+	sort.Slice(ws, func(i, j int) bool {
+		return ws[i].Staleness > ws[j].Staleness
+	})
+	m.respondTimes(c, ws, loc)
 }
 
-func (m *Manager) getJobConfig(c *gin.Context) {
+// staleMirror tells whether the job is a mirror not synced within
+// threshold, or never synced
+func staleMirror(v *v1beta1.Job, now time.Time, threshold time.Duration) (internal.StaleMirror, bool) {
+	// only mirrors sync on their own
+	switch v.Spec.Config.Type {
+	case v1beta1.Mirror, "":
+	default:
+		return internal.StaleMirror{}, false
+	}
+	if v.Status.Status == v1beta1.Disabled {
+		return internal.StaleMirror{}, false
+	}
+
+	w := internal.StaleMirror{MirrorStatus: mirrorStatus(v, now)}
+	since := time.Unix(v.Status.LastUpdate, 0)
+	if v.Status.LastUpdate == 0 {
+		w.NeverSynced = true
+		since = v.CreationTimestamp.Time
+	}
+	if !w.NeverSynced && now.Sub(since) < threshold {
+		return internal.StaleMirror{}, false
+	}
+	w.Staleness = int64(now.Sub(since).Seconds())
+	return w, true
+}
+
+func (m *Manager) getJob(c *gin.Context) {
 	mirrorID := c.Param("id")
-	var config internal.MirrorConfig
+	loc, ok := m.timeFormat(c)
+	if !ok {
+		return
+	}
 
 	m.rwmu.RLock()
 	defer m.rwmu.RUnlock()
-	job, err := m.GetJob(c, mirrorID)
-	config = internal.MirrorConfig{ID: mirrorID, JobSpec: job.Spec}
 
+	job, err := m.GetJob(c, mirrorID)
 	if err != nil {
-		err := fmt.Errorf("failed to get job %s: %s",
-			mirrorID, err.Error(),
+		err := fmt.Errorf("failed to get job %s: %w",
+			mirrorID, err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
-	c.JSON(http.StatusOK, config)
+	w := mirrorStatus(job, m.now())
+	// unlike listings, the whole status as stored
+	w.JobStatus = job.Status
+	c.Header(etagHeader, `"`+job.ResourceVersion+`"`)
+	m.respondTimes(c, w, loc)
 }
 
-func (m *Manager) getJobLatestLog(c *gin.Context) {
+// headJob responds 200 with the sync status in X-Mirror-Status, or the
+// status code of the GET when the mirror can't be got
+func (m *Manager) headJob(c *gin.Context) {
 	mirrorID := c.Param("id")
-	runLog.Info(fmt.Sprintf("Geting log from <%s>", mirrorID))
-	resp, err := m.httpClient.Get(fmt.Sprintf("http://%s:6000/log", mirrorID))
 
-	if err != nil {
-		err := fmt.Errorf("get log from mirror %s fail: %s", mirrorID, err.Error())
-		c.Error(err)
-		c.String(http.StatusInternalServerError, err.Error())
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+
+	job := new(v1beta1.Job)
+	if err := m.client.Get(c.Request.Context(), client.ObjectKey{Name: mirrorID}, job); err != nil {
+		status, _ := errorStatus(http.StatusInternalServerError, err)
+		c.Status(status)
 		return
 	}
-
-	defer resp.Body.Close()
-	bodyText, err := io.ReadAll(resp.Body)
-
-	c.String(http.StatusOK, string(bodyText))
+	c.Header(mirrorStatusHeader, string(mirrorStatus(job, m.now()).Status))
+	c.Status(http.StatusOK)
+}
+
+func (m *Manager) getJobConfig(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	c.JSON(http.StatusOK, internal.MirrorConfig{ID: mirrorID, JobSpec: job.Spec})
+}
+
+func (m *Manager) getJobHistory(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	history := job.Status.History
+	if history == nil {
+		history = []v1beta1.SyncRecord{}
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// getJobSizeTrend reports the size change over the last n successful syncs
+func (m *Manager) getJobSizeTrend(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	n, err := strconv.Atoi(c.DefaultQuery("n", "0"))
+	if err != nil || n < 0 {
+		err := fmt.Errorf("invalid n: %s", c.Query("n"))
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", defaultShrinkPercent), 64)
+	if err != nil || threshold < 0 {
+		err := fmt.Errorf("invalid threshold: %s", c.Query("threshold"))
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+
+	trend := internal.SizeTrend{ID: mirrorID, Points: []internal.SizePoint{}}
+	for _, r := range job.Status.History {
+		if r.Status == v1beta1.Success && r.Size > 0 {
+			trend.Points = append(trend.Points, internal.SizePoint{Time: r.Time, Size: r.Size})
+		}
+	}
+	if n > 0 && len(trend.Points) > n {
+		trend.Points = trend.Points[len(trend.Points)-n:]
+	}
+	if len(trend.Points) > 1 {
+		first, last := trend.Points[0].Size, trend.Points[len(trend.Points)-1].Size
+		trend.Delta = int64(last) - int64(first)
+		trend.Percent = float64(trend.Delta) / float64(first) * 100
+		trend.Shrunk = trend.Percent <= -threshold
+	}
+	c.JSON(http.StatusOK, trend)
+}
+
+func (m *Manager) getJobLatestLog(c *gin.Context) {
+	mirrorID := c.Param("id")
+	runLog.Info(fmt.Sprintf("Geting log from <%s>", mirrorID))
+	resp, err := m.httpClient.Get(fmt.Sprintf("http://%s:6000/log", mirrorID))
+
+	if err != nil {
+		err := fmt.Errorf("get log from mirror %s fail: %w", mirrorID, err)
+		c.Error(err)
+
+		// fall back to the log tail reported with the latest status
+		m.rwmu.RLock()
+		defer m.rwmu.RUnlock()
+		job := new(v1beta1.Job)
+		if e := m.client.Get(c.Request.Context(), client.ObjectKey{Name: mirrorID}, job); e == nil && job.Status.LogTail != "" {
+			c.String(http.StatusOK, job.Status.LogTail)
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	defer resp.Body.Close()
+	bodyText, err := io.ReadAll(resp.Body)
+
+	c.String(http.StatusOK, string(bodyText))
 }
 
 // deleteJob deletes one job by id
@@ -476,8 +1386,8 @@ func (m *Manager) deleteJob(c *gin.Context) {
 	}
 	err = m.client.Delete(c.Request.Context(), job)
 	if err != nil {
-		err := fmt.Errorf("failed to delete mirror: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to delete mirror: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -500,12 +1410,13 @@ func (m *Manager) registerMirror(c *gin.Context) {
 		return
 	}
 
-	job.Status.LastOnline = time.Now().Unix()
-	job.Status.LastRegister = time.Now().Unix()
+	now := m.now().Unix()
+	job.Status.LastOnline = now
+	job.Status.LastRegister = now
 	err = m.client.Status().Update(c.Request.Context(), job)
 	if err != nil {
-		err := fmt.Errorf("failed to register mirror %s: %s",
-			mirrorID, err.Error(),
+		err := fmt.Errorf("failed to register mirror %s: %w",
+			mirrorID, err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -517,16 +1428,115 @@ func (m *Manager) registerMirror(c *gin.Context) {
 }
 
 func (m *Manager) returnErrJSON(c *gin.Context, code int, err error) {
+	code, errCode := errorStatus(code, err)
+	setRetryAfter(c, code, err)
 	c.JSON(code, gin.H{
 		_errorKey: err.Error(),
+		_codeKey:  errCode,
 	})
 }
 
+// scheduleLocation parses the ?tz param, nil means no tz is requested
+func (m *Manager) scheduleLocation(c *gin.Context) (*time.Location, bool) {
+	tz := c.Query("tz")
+	if tz == "" {
+		return nil, true
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		err := fmt.Errorf("invalid tz %s: %w", tz, err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return loc, true
+}
+
+// timeFormat parses the ?timeFormat param, rfc3339 adds the timestamps
+// as strings in the location of ?tz, UTC by default, nil means unix only
+func (m *Manager) timeFormat(c *gin.Context) (*time.Location, bool) {
+	switch format := c.DefaultQuery("timeFormat", "unix"); format {
+	case "unix":
+		return nil, true
+	case "rfc3339":
+		loc, ok := m.scheduleLocation(c)
+		if ok && loc == nil {
+			loc = time.UTC
+		}
+		return loc, ok
+	default:
+		err := fmt.Errorf("invalid timeFormat %s, must be unix or rfc3339", format)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+}
+
+// respondTimes responds with obj, along with the timestamps as strings
+// when loc is set
+func (m *Manager) respondTimes(c *gin.Context, obj interface{}, loc *time.Location) {
+	if loc == nil {
+		c.JSON(http.StatusOK, obj)
+		return
+	}
+	full, err := withTimes(obj, loc)
+	if err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, full)
+}
+
+func formatSchedule(scheduled int64, loc *time.Location) internal.MirrorSchedule {
+	schedule := internal.MirrorSchedule{NextSchedule: scheduled}
+	if loc != nil && scheduled > 0 {
+		schedule.NextScheduleTime = time.Unix(scheduled, 0).In(loc).Format(time.RFC3339)
+	}
+	return schedule
+}
+
+func (m *Manager) getSchedule(c *gin.Context) {
+	mirrorID := c.Param("id")
+	loc, ok := m.scheduleLocation(c)
+	if !ok {
+		return
+	}
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	job, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	c.JSON(http.StatusOK, formatSchedule(job.Status.Scheduled, loc))
+}
+
 func (m *Manager) updateSchedule(c *gin.Context) {
 	mirrorID := c.Param("id")
 	type empty struct{}
+	loc, ok := m.scheduleLocation(c)
+	if !ok {
+		return
+	}
+	// workers don't ask for a tz and keep getting an empty body
+	respond := func(scheduled int64) {
+		if loc == nil {
+			c.JSON(http.StatusOK, empty{})
+			return
+		}
+		c.JSON(http.StatusOK, formatSchedule(scheduled, loc))
+	}
 	var schedule internal.MirrorSchedule
-	c.BindJSON(&schedule)
+	if !m.bindJSON(c, &schedule) {
+		return
+	}
+	if err := validateSchedule(schedule.NextSchedule, m.now()); err != nil {
+		err := fmt.Errorf("invalid schedule of job %s: %w", mirrorID, err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
 
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
@@ -534,117 +1544,319 @@ func (m *Manager) updateSchedule(c *gin.Context) {
 
 	if err != nil {
 		runLog.Error(err, fmt.Sprintf("failed to get job %s: %s", mirrorID, err.Error()))
-		c.JSON(http.StatusOK, empty{})
+		return
 	}
 
 	if curJob.Status.Scheduled == schedule.NextSchedule {
 		// no changes, skip update
-		c.JSON(http.StatusOK, empty{})
+		respond(curJob.Status.Scheduled)
+		return
 	}
 
 	curJob.Status.Scheduled = schedule.NextSchedule
-	curJob.Status.LastOnline = time.Now().Unix()
+	curJob.Status.LastOnline = m.now().Unix()
 	err = m.client.Status().Update(c.Request.Context(), curJob)
 	if err != nil {
-		err := fmt.Errorf("failed to update job %s: %s",
-			mirrorID, err.Error(),
+		err := fmt.Errorf("failed to update job %s: %w",
+			mirrorID, err,
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	respond(curJob.Status.Scheduled)
+}
+
+// patchJob applies a json merge patch to the job status, leaving the
+// fields not in the patch untouched
+func (m *Manager) patchJob(c *gin.Context) {
+	mirrorID := c.Param("id")
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	curJob, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+
+	patch := client.MergeFrom(curJob.DeepCopy())
+	status := curJob.Status
+	// the keys tell whether the status is reported
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(body, &fields); err == nil {
+		err = json.Unmarshal(body, &status)
+	}
+	if err != nil {
+		err := fmt.Errorf("invalid status patch for job %s: %w", mirrorID, err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	_, reported := fields["status"]
+	if m.staleReport(&curJob.Status, &status) {
+		m.discardReport(c, curJob, status.Status)
+		return
+	}
+	if status.Status != curJob.Status.Status && !m.checkReportedStatus(c, mirrorID, &status.Status) {
+		return
+	}
+	if err = checkStatusTransition(curJob.Status.Status, status.Status); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusConflict, err)
+		return
+	}
+	if err = m.checkMaintenance(curJob.Status.Status, status.Status); err != nil {
+		m.returnErrJSON(c, http.StatusServiceUnavailable, err)
+		return
+	}
+	if startsSync(curJob.Status.Status, status.Status) {
+		if err = m.checkDependencies(c.Request.Context(), curJob); err != nil {
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusConflict, err)
+			return
+		}
+	}
+	if status.LogTail != curJob.Status.LogTail {
+		status.LogTail = internal.TruncateLogTail(status.LogTail, internal.MaxLogTail)
+	}
+	m.finishStatus(c.Request.Context(), curJob, &status, reported)
+
+	curJob.Status = status
+	if err = m.client.Status().Patch(c.Request.Context(), curJob, patch); err != nil {
+		err := fmt.Errorf("failed to patch job %s: %w",
+			mirrorID, err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
-	c.JSON(http.StatusOK, empty{})
+	c.JSON(http.StatusOK, curJob.Status)
 }
 
 func (m *Manager) updateJob(c *gin.Context) {
+	// partial updates, the whole status is replaced otherwise
+	if c.ContentType() == mergePatchJSON {
+		m.patchJob(c)
+		return
+	}
+
 	mirrorID := c.Param("id")
 	var status v1beta1.JobStatus
-	c.BindJSON(&status)
+	if !m.bindJSON(c, &status) {
+		return
+	}
+	if !m.checkReportedStatus(c, mirrorID, &status.Status) {
+		return
+	}
 
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
 	curJob, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	if m.staleReport(&curJob.Status, &status) {
+		m.discardReport(c, curJob, status.Status)
+		return
+	}
+	if status.ReportedAt == 0 {
+		status.ReportedAt = curJob.Status.ReportedAt
+	}
+	if err = checkStatusTransition(curJob.Status.Status, status.Status); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusConflict, err)
+		return
+	}
+	if err = m.checkMaintenance(curJob.Status.Status, status.Status); err != nil {
+		m.returnErrJSON(c, http.StatusServiceUnavailable, err)
+		return
+	}
+	if startsSync(curJob.Status.Status, status.Status) {
+		if err = m.checkDependencies(c.Request.Context(), curJob); err != nil {
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusConflict, err)
+			return
+		}
+	}
+
+	// Only message with meaningful size updates the mirror size
+	if curJob.Status.Size > 0 {
+		if status.Size == 0 {
+			status.Size = curJob.Status.Size
+		}
+	}
+	if status.DiskSize == 0 {
+		status.DiskSize = curJob.Status.DiskSize
+	}
+	if status.CompressedSize == 0 {
+		status.CompressedSize = curJob.Status.CompressedSize
+	}
+
+	// Only message with log tail updates the stored log
+	if status.LogTail == "" {
+		status.LogTail = curJob.Status.LogTail
+	} else {
+		status.LogTail = internal.TruncateLogTail(status.LogTail, internal.MaxLogTail)
+	}
+
+	m.finishStatus(c.Request.Context(), curJob, &status, true)
+
+	curJob.Status = status
+	err = m.client.Status().Update(c.Request.Context(), curJob)
+	if err != nil {
+		err := fmt.Errorf("failed to update job %s: %w",
+			mirrorID, err,
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
 
-	curTime := time.Now().Unix()
+// finishStatus sets what the manager keeps of a status reported for job:
+// the times and the history of the syncs, the failures in a row and the
+// fields only the manager writes. A patch leaving the status out reports
+// no sync, the times and failures stored are kept then.
+func (m *Manager) finishStatus(ctx context.Context, job *v1beta1.Job, status *v1beta1.JobStatus, reported bool) {
+	cur := &job.Status
+	curTime := m.now().Unix()
 
 	status.LastOnline = curTime
-	status.LastRegister = curJob.Status.LastRegister
+	status.LastRegister = cur.LastRegister
+	status.History = cur.History
+	// set by the manager only
+	status.BoostUntil = cur.BoostUntil
+	status.BoostInterval = cur.BoostInterval
+	status.TokenHash = cur.TokenHash
+
+	if !reported {
+		status.LastStarted = cur.LastStarted
+		status.LastUpdate = cur.LastUpdate
+		status.LastEnded = cur.LastEnded
+		status.ConsecutiveFailures = cur.ConsecutiveFailures
+		status.NextRetry = cur.NextRetry
+		return
+	}
 
-	if status.Status == v1beta1.PreSyncing && curJob.Status.Status != v1beta1.PreSyncing {
+	if status.Status == v1beta1.PreSyncing && cur.Status != v1beta1.PreSyncing {
 		status.LastStarted = curTime
 	} else {
-		status.LastStarted = curJob.Status.LastStarted
+		status.LastStarted = cur.LastStarted
 	}
 	// Only successful syncing needs last_update
 	if status.Status == v1beta1.Success {
 		status.LastUpdate = curTime
 	} else {
-		status.LastUpdate = curJob.Status.LastUpdate
+		status.LastUpdate = cur.LastUpdate
 	}
 	if status.Status == v1beta1.Success || status.Status == v1beta1.Failed {
 		status.LastEnded = curTime
 	} else {
-		status.LastEnded = curJob.Status.LastEnded
+		status.LastEnded = cur.LastEnded
 	}
 
-	// Only message with meaningful size updates the mirror size
-	if curJob.Status.Size > 0 {
-		if status.Size == 0 {
-			status.Size = curJob.Status.Size
+	// Recommend when to retry a failed sync
+	switch status.Status {
+	case v1beta1.Success:
+		status.ConsecutiveFailures = 0
+	case v1beta1.Failed:
+		status.ConsecutiveFailures = cur.ConsecutiveFailures + 1
+		status.NextRetry = nextRetry(&job.Spec.Config, status.ConsecutiveFailures, curTime)
+		// stop a job failing forever, until it's started again
+		if m.options().AutoPauseFailures > 0 && status.ConsecutiveFailures >= m.options().AutoPauseFailures {
+			status.Status = v1beta1.Paused
+			status.NextRetry = 0
+			msg := fmt.Sprintf("paused after %d consecutive failures", status.ConsecutiveFailures)
+			runLog.Info(fmt.Sprintf("Job [%s] %s", job.Name, msg))
+			m.recordEvent(ctx, job, corev1.EventTypeWarning, "AutoPaused", msg)
+		}
+	default:
+		status.ConsecutiveFailures = cur.ConsecutiveFailures
+		status.NextRetry = cur.NextRetry
+	}
+
+	// Keep a bounded history of finished syncs
+	if status.Status == v1beta1.Success || status.Status == v1beta1.Failed {
+		record := v1beta1.SyncRecord{Time: curTime, Status: status.Status, Size: status.Size}
+		if status.LastStarted != 0 {
+			record.Duration = curTime - status.LastStarted
+		}
+		status.History = append(status.History, record)
+		if len(status.History) > m.options().HistoryLimit {
+			status.History = status.History[len(status.History)-m.options().HistoryLimit:]
 		}
 	}
 
 	// for logging
 	switch status.Status {
 	case v1beta1.Syncing:
-		runLog.Info(fmt.Sprintf("Job [%s] starts syncing", mirrorID))
+		runLog.Info(fmt.Sprintf("Job [%s] starts syncing", job.Name))
 	default:
-		runLog.Info(fmt.Sprintf("Job [%s] %s", mirrorID, status.Status))
-	}
-
-	curJob.Status = status
-	err = m.client.Status().Update(c.Request.Context(), curJob)
-	if err != nil {
-		err := fmt.Errorf("failed to update job %s: %s",
-			mirrorID, err.Error(),
-		)
-		c.Error(err)
-		m.returnErrJSON(c, http.StatusInternalServerError, err)
-		return
+		runLog.Info(fmt.Sprintf("Job [%s] %s", job.Name, status.Status))
 	}
-	c.JSON(http.StatusOK, status)
 }
 
 func (m *Manager) updateMirrorSize(c *gin.Context) {
 	mirrorID := c.Param("id")
+	// either size may be left out, keeping the stored one. A compressed
+	// mirror reports its compressed size or the ratio of the size to it.
 	type SizeMsg struct {
-		Size uint64 `json:"size"`
+		Size             *uint64  `json:"size"`
+		DiskSize         *uint64  `json:"diskSize"`
+		CompressedSize   *uint64  `json:"compressedSize"`
+		CompressionRatio *float64 `json:"compressionRatio"`
 	}
 	var msg SizeMsg
-	c.BindJSON(&msg)
-
-	m.rwmu.Lock()
-	defer m.rwmu.Unlock()
-	job, err := m.GetJob(c, mirrorID)
-
-	if err != nil {
-		runLog.Error(err, fmt.Sprintf("Failed to get status of job %s: %s", mirrorID, err.Error()))
-		m.returnErrJSON(c, http.StatusInternalServerError, err)
+	if !m.bindJSON(c, &msg) {
+		return
+	}
+	if err := validateCompression(msg.CompressedSize, msg.CompressionRatio); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
 		return
 	}
 
-	job.Status.Size = msg.Size
-	runLog.Info(fmt.Sprintf("Mirror size of [%s]: %d", mirrorID, job.Status.Size))
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
 
-	err = m.client.Status().Update(c.Request.Context(), job)
+	// the patch only touches the size, so a status written meanwhile
+	// by others is kept
+	job := new(v1beta1.Job)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := m.client.Get(c.Request.Context(), client.ObjectKey{Name: mirrorID}, job); err != nil {
+			return err
+		}
+		patch := client.MergeFrom(job.DeepCopy())
+		if msg.Size != nil {
+			job.Status.Size = *msg.Size
+		}
+		if msg.DiskSize != nil {
+			job.Status.DiskSize = *msg.DiskSize
+		}
+		if msg.CompressedSize != nil {
+			job.Status.CompressedSize = *msg.CompressedSize
+		}
+		if msg.CompressionRatio != nil {
+			job.Status.CompressedSize = uint64(float64(job.Status.Size) / *msg.CompressionRatio)
+		}
+		return m.client.Status().Patch(c.Request.Context(), job, patch)
+	})
 	if err != nil {
-		err := fmt.Errorf("failed to update job %s: %s",
-			mirrorID, err.Error(),
+		err := fmt.Errorf("failed to update size of job %s: %w",
+			mirrorID, err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	runLog.Info(fmt.Sprintf("Mirror size of [%s]: %d, %d on disk, %d compressed", mirrorID, job.Status.Size, job.Status.DiskSize, job.Status.CompressedSize))
 	c.JSON(http.StatusOK, job)
 }
 
@@ -659,14 +1871,21 @@ func (m *Manager) enableJob(c *gin.Context) {
 		runLog.Error(err, fmt.Sprintf("failed to get job %s: %s", mirrorID, err.Error()))
 		return
 	}
+	if !m.ifMatch(c, mirrorID, curJob) {
+		return
+	}
 
-	curJob.Status.Status = v1beta1.Created
-	curJob.Status.LastOnline = time.Now().Unix()
+	if err = applyStatusTransition(&curJob.Status, v1beta1.Created); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusConflict, err)
+		return
+	}
+	curJob.Status.LastOnline = m.now().Unix()
 	err = m.client.Status().Update(c.Request.Context(), curJob)
 
 	if err != nil {
-		err := fmt.Errorf("failed to enable mirror: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to enable mirror: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -687,13 +1906,20 @@ func (m *Manager) disableJob(c *gin.Context) {
 		runLog.Error(err, fmt.Sprintf("failed to get job %s: %s", mirrorID, err.Error()))
 		return
 	}
+	if !m.ifMatch(c, mirrorID, curJob) {
+		return
+	}
 
-	curJob.Status.Status = v1beta1.Disabled
-	curJob.Status.LastOnline = time.Now().Unix()
+	if err = applyStatusTransition(&curJob.Status, v1beta1.Disabled); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusConflict, err)
+		return
+	}
+	curJob.Status.LastOnline = m.now().Unix()
 	err = m.client.Status().Update(c.Request.Context(), curJob)
 	if err != nil {
-		err := fmt.Errorf("failed to disable mirror: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to disable mirror: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -703,68 +1929,388 @@ func (m *Manager) disableJob(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{_infoKey: "disabled"})
 }
 
-// PostJSON posts json object to url
+// offlineJob marks the mirror offline when its worker shuts down cleanly,
+// instead of leaving the sync killed by the shutdown as failed. Paused
+// and disabled mirrors are left as they are.
+func (m *Manager) offlineJob(c *gin.Context) {
+	mirrorID := c.Param("id")
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	curJob, err := m.GetJob(c, mirrorID)
+
+	if err != nil {
+		runLog.Error(err, fmt.Sprintf("failed to get job %s: %s", mirrorID, err.Error()))
+		return
+	}
+
+	switch curJob.Status.Status {
+	case v1beta1.Paused, v1beta1.Disabled:
+	default:
+		if err = applyStatusTransition(&curJob.Status, v1beta1.Offline); err != nil {
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusConflict, err)
+			return
+		}
+	}
+	curJob.Status.LastOnline = m.now().Unix()
+	err = m.client.Status().Update(c.Request.Context(), curJob)
+	if err != nil {
+		err := fmt.Errorf("failed to set mirror offline: %w",
+			err,
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	// offline on purpose, not to be restored by heartbeats
+	m.heartbeats.forget(mirrorID)
+	runLog.Info(fmt.Sprintf("Mirror <%s> deregistered", mirrorID))
+	c.JSON(http.StatusOK, gin.H{_infoKey: "offline"})
+}
+
+func (m *Manager) updateNote(c *gin.Context) {
+	mirrorID := c.Param("id")
+	var note internal.MirrorNote
+	if !m.bindJSON(c, &note) {
+		return
+	}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	curJob, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+
+	if !m.ifMatch(c, mirrorID, curJob) {
+		return
+	}
+
+	// the note lives in spec so that status updates never touch it
+	patch := mergeFrom(c, curJob.DeepCopy())
+	curJob.Spec.Config.Note = note.Note
+	if err = m.client.Patch(c.Request.Context(), curJob, patch); err != nil {
+		err := fmt.Errorf("failed to update note of job %s: %w",
+			mirrorID, err,
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	runLog.Info(fmt.Sprintf("Note of mirror <%s> updated", mirrorID))
+	c.JSON(http.StatusOK, gin.H{_infoKey: "note updated"})
+}
+
+// forceStatus sets the status of a job regardless of the usual
+// transitions and timestamps
+func (m *Manager) forceStatus(c *gin.Context) {
+	mirrorID := c.Param("id")
+	var msg internal.MirrorForceStatus
+	if !m.bindJSON(c, &msg) {
+		return
+	}
+	if !internal.IsSyncStatus(msg.Status) {
+		err := fmt.Errorf("unknown status: %s", msg.Status)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	curJob, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	// a status reported since it was read is not overwritten
+	if !m.ifMatch(c, mirrorID, curJob) {
+		return
+	}
+
+	from := curJob.Status.Status
+	curJob.Status.Status = msg.Status
+	err = m.client.Status().Update(c.Request.Context(), curJob)
+	if err != nil {
+		err := fmt.Errorf("failed to force status of job %s: %w",
+			mirrorID, err,
+		)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	runLog.Info(fmt.Sprintf("Status of mirror <%s> forced", mirrorID),
+		"from", from, "to", msg.Status, "by", c.GetString(identityKey), "reason", msg.Reason)
+	c.JSON(http.StatusOK, curJob.Status)
+}
+
+// PostJSON posts json object to the worker of the mirror, retrying
+// Options.CmdRetries times with backoff when the worker is unreachable
 func (m *Manager) PostJSON(mirrorID string, obj interface{}) (*http.Response, error) {
-	b := new(bytes.Buffer)
-	if err := json.NewEncoder(b).Encode(obj); err != nil {
+	return m.postJSON(m.internal, mirrorID, obj, m.options().CmdTimeout.Duration)
+}
+
+// postJSON is PostJSON waiting timeout for every try, it gives up on the
+// retries when ctx is done
+func (m *Manager) postJSON(ctx context.Context, mirrorID string, obj interface{}, timeout time.Duration) (*http.Response, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
 		return nil, err
 	}
-	return m.httpClient.Post(fmt.Sprintf("http://%s:6000", mirrorID), "application/json; charset=utf-8", b)
+	url := fmt.Sprintf("http://%s:6000", mirrorID)
+	// shares the connections of cmdClient
+	hc := &http.Client{Transport: m.cmdClient.Transport, Timeout: timeout}
+
+	backoff := defaultCmdBackoff
+	for i := 0; ; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		r, err := hc.Do(req)
+		if err == nil && !retryableStatus(r.StatusCode) {
+			return r, nil
+		}
+		if i >= m.options().CmdRetries {
+			return r, err
+		}
+		if err == nil {
+			r.Body.Close()
+			err = fmt.Errorf("worker responded %d", r.StatusCode)
+		}
+		runLog.Info(fmt.Sprintf("Retrying command to <%s> in %s: %s", mirrorID, backoff, err.Error()))
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// cmdTimeout is the CallbackTimeout of the mirror, or Options.CmdTimeout
+func (m *Manager) cmdTimeout(mirrorID string) time.Duration {
+	job := new(v1beta1.Job)
+	if err := m.client.Get(m.internal, client.ObjectKey{Name: mirrorID}, job); err == nil && job.Spec.Config.CallbackTimeout > 0 {
+		return time.Duration(job.Spec.Config.CallbackTimeout) * time.Second
+	}
+	return m.options().CmdTimeout.Duration
+}
+
+// retryableStatus tells the worker is possibly restarting
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func listCommands(c *gin.Context) {
+	c.JSON(http.StatusOK, internal.CmdVerbs)
 }
 
 func (m *Manager) handleClientCmd(c *gin.Context) {
 	mirrorID := c.Param("id")
 	var clientCmd internal.ClientCmd
-	c.BindJSON(&clientCmd)
+	if !m.bindJSON(c, &clientCmd) {
+		return
+	}
 
+	// the lock is released before the command is posted, which may take
+	// the retries of an unreachable worker
 	switch clientCmd.Cmd {
-	case internal.CmdStop:
+	case internal.CmdPing:
+		m.pingJob(c, mirrorID, clientCmd)
+		return
+	case internal.CmdStart:
 		m.rwmu.Lock()
-		defer m.rwmu.Unlock()
+		ctx := c.Request.Context()
+		err := m.updateJobStatus(ctx, mirrorID, func(job *v1beta1.Job) (bool, error) {
+			if err := m.checkDependencies(ctx, job); err != nil {
+				return false, err
+			}
+			// a started job gets a fresh failure count
+			if job.Status.ConsecutiveFailures == 0 && job.Status.NextRetry == 0 {
+				return false, nil
+			}
+			job.Status.ConsecutiveFailures = 0
+			job.Status.NextRetry = 0
+			return true, nil
+		})
+		m.rwmu.Unlock()
+		if err != nil {
+			m.returnCmdErr(c, err)
+			return
+		}
+	case internal.CmdRestart:
+		m.rwmu.RLock()
 		curJob, err := m.GetJob(c, mirrorID)
+		if err == nil {
+			if err = m.checkDependencies(c.Request.Context(), curJob); err != nil {
+				c.Error(err)
+				m.returnErrJSON(c, http.StatusConflict, err)
+			}
+		}
+		m.rwmu.RUnlock()
 		if err != nil {
-			runLog.Error(err, fmt.Sprintf("failed to get job %s: %s", mirrorID, err.Error()))
+			return
+		}
+	case internal.CmdStop, internal.CmdDisable:
+		switch clientCmd.Mode {
+		case "", internal.StopTerminate, internal.StopGraceful:
+		default:
+			err := fmt.Errorf("unknown stop mode %s", clientCmd.Mode)
+			c.Error(err)
+			m.returnErrJSON(c, http.StatusBadRequest, err)
 			return
 		}
 
-		curJob.Status.Status = v1beta1.Paused
-		curJob.Status.LastOnline = time.Now().Unix()
-		err = m.client.Status().Update(c.Request.Context(), curJob)
+		m.rwmu.Lock()
+		graceful := false
+		err := m.updateJobStatus(c.Request.Context(), mirrorID, func(job *v1beta1.Job) (bool, error) {
+			// a graceful stop of a running sync leaves the status to the
+			// worker, which reports paused once the sync finishes
+			graceful = clientCmd.Cmd == internal.CmdStop && clientCmd.Mode == internal.StopGraceful &&
+				(job.Status.Status == v1beta1.PreSyncing || job.Status.Status == v1beta1.Syncing)
+			if graceful {
+				return false, nil
+			}
+
+			to := v1beta1.Paused
+			if clientCmd.Cmd == internal.CmdDisable {
+				to = v1beta1.Disabled
+			}
+			if err := applyStatusTransition(&job.Status, to); err != nil {
+				return false, err
+			}
+			job.Status.LastOnline = m.now().Unix()
+			return true, nil
+		})
+		m.rwmu.Unlock()
 		if err != nil {
-			runLog.Error(err, fmt.Sprintf("failed to update job %s: %s", mirrorID, err.Error()))
+			m.returnCmdErr(c, err)
 			return
 		}
+		if clientCmd.Cmd == internal.CmdStop && !graceful {
+			clientCmd.Mode = internal.StopTerminate
+		}
 	}
 
-	runLog.Info(fmt.Sprintf("Posting command '%s' to <%s>", clientCmd.Cmd, mirrorID))
-	// post command to mirror
-	r, err := m.PostJSON(mirrorID, clientCmd)
+	if code, err := m.sendCmd(c.Request.Context(), mirrorID, clientCmd); err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, code, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{_infoKey: "successfully send command to mirror " + mirrorID})
+}
+
+// updateJobStatus gets the job and writes the status changed by update,
+// which is called again with the latest job on a conflict, so that the
+// change is never made to a stale status. Nothing is written when update
+// reports no change.
+func (m *Manager) updateJobStatus(ctx context.Context, mirrorID string, update func(job *v1beta1.Job) (bool, error)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		job := new(v1beta1.Job)
+		if err := m.client.Get(ctx, client.ObjectKey{Name: mirrorID}, job); err != nil {
+			return fmt.Errorf("failed to get job %s: %w", mirrorID, err)
+		}
+		changed, err := update(job)
+		if err != nil || !changed {
+			return err
+		}
+		if err = m.client.Status().Update(ctx, job); err != nil {
+			return fmt.Errorf("failed to update job %s: %w", mirrorID, err)
+		}
+		return nil
+	})
+}
+
+// returnCmdErr responds with the error of a command, 409 when the job is
+// not in a state to run it
+func (m *Manager) returnCmdErr(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	var transition *transitionError
+	if errors.As(err, &transition) || errors.Is(err, errDependency) {
+		status = http.StatusConflict
+	}
+	c.Error(err)
+	m.returnErrJSON(c, status, err)
+}
+
+// pingJob checks the worker of the mirror is alive, an ack bumps the
+// LastOnline of the mirror and leaves its sync status as is
+func (m *Manager) pingJob(c *gin.Context, mirrorID string, clientCmd internal.ClientCmd) {
+	start := time.Now()
+	code, err := m.sendCmd(c.Request.Context(), mirrorID, clientCmd)
 	if err != nil {
-		err := fmt.Errorf("post command to mirror %s fail: %s", mirrorID, err.Error())
+		c.Error(err)
+		m.returnErrJSON(c, code, err)
+		return
+	}
+	rtt := time.Since(start)
+
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	curJob, err := m.GetJob(c, mirrorID)
+	if err != nil {
+		return
+	}
+	curJob.Status.LastOnline = m.now().Unix()
+	if err = m.client.Status().Update(c.Request.Context(), curJob); err != nil {
+		err := fmt.Errorf("failed to update mirror %s: %w", mirrorID, err)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
-	if r.StatusCode == 200 {
-		c.JSON(http.StatusOK, gin.H{_infoKey: "successfully send command to mirror " + mirrorID})
-	} else {
-		defer r.Body.Close()
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			c.Error(err)
-			m.returnErrJSON(c, http.StatusInternalServerError, err)
-			return
-		}
-		c.String(r.StatusCode, string(body))
+	c.JSON(http.StatusOK, internal.PingResult{RoundTrip: rtt.Milliseconds(), LastOnline: curJob.Status.LastOnline})
+}
+
+// sendCmd posts the command to the worker of the mirror, and returns the
+// status code to respond with when it's not accepted
+func (m *Manager) sendCmd(ctx context.Context, mirrorID string, clientCmd internal.ClientCmd) (int, error) {
+	runLog.Info(fmt.Sprintf("Posting command '%s' to <%s>", clientCmd.Cmd, mirrorID))
+	return m.postCmd(ctx, mirrorID, clientCmd)
+}
+
+// postCmd is sendCmd without logging, for the periodic heartbeats
+func (m *Manager) postCmd(ctx context.Context, mirrorID string, clientCmd internal.ClientCmd) (int, error) {
+	// post command to mirror
+	r, err := m.postJSON(ctx, mirrorID, clientCmd, m.cmdTimeout(mirrorID))
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("post command to mirror %s fail: %w", mirrorID, err)
+	}
+	defer r.Body.Close()
+
+	// only an explicit ack from the worker means the command took effect
+	var ack internal.CmdAck
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &ack)
+	}
+	if err == nil && r.StatusCode == http.StatusOK && ack.Msg == internal.CmdAckOK {
+		return http.StatusOK, nil
+	}
+
+	code := r.StatusCode
+	if err != nil || code < http.StatusBadRequest || code >= http.StatusInternalServerError {
+		code = http.StatusBadGateway
+	}
+	if ack.Msg == "" {
+		ack.Msg = strings.TrimSpace(string(body))
 	}
+	return code, fmt.Errorf("mirror %s rejected command '%s' with %d: %s", mirrorID, clientCmd.Cmd, r.StatusCode, ack.Msg)
 }
 
 func (m *Manager) GetAnnouncement(c *gin.Context, announcementID string) (*v1beta1.Announcement, error) {
 	news := new(v1beta1.Announcement)
 	err := m.client.Get(c.Request.Context(), client.ObjectKey{Name: announcementID}, news)
 	if err != nil {
-		err := fmt.Errorf("failed to get announcement: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to get announcement: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -786,11 +2332,15 @@ func (m *Manager) createAnnouncement(c *gin.Context) {
 	}
 	if err := m.client.Get(c.Request.Context(), client.ObjectKey{Name: announcementID}, oNews); err != nil || oNews == nil {
 		var newsSpec v1beta1.AnnouncementSpec
-		c.BindJSON(&newsSpec)
+		if !m.bindJSON(c, &newsSpec) {
+			return
+		}
 		news.Spec = newsSpec
 	} else {
 		newsSpec := make(map[string]string)
-		c.BindJSON(&newsSpec)
+		if !m.bindJSON(c, &newsSpec) {
+			return
+		}
 		if v, ok := newsSpec["title"]; ok {
 			oNews.Spec.Title = v
 		}
@@ -805,8 +2355,8 @@ func (m *Manager) createAnnouncement(c *gin.Context) {
 
 	e = m.client.Patch(c.Request.Context(), &news, client.Apply, []client.PatchOption{client.ForceOwnership, client.FieldOwner("mirror-controller")}...)
 	if e != nil {
-		err := fmt.Errorf("failed to patch announcement %s: %s",
-			announcementID, e.Error(),
+		err := fmt.Errorf("failed to patch announcement %s: %w",
+			announcementID, e,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -840,8 +2390,8 @@ func (m *Manager) listAnnouncement(c *gin.Context) {
 	})
 
 	if err != nil {
-		err := fmt.Errorf("failed to list announcements: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to list announcements: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -858,8 +2408,8 @@ func (m *Manager) getAnnouncement(c *gin.Context) {
 
 	announcement, err := m.GetAnnouncement(c, announcementID)
 	if err != nil {
-		err := fmt.Errorf("failed to get announcement %s: %s",
-			announcementID, err.Error(),
+		err := fmt.Errorf("failed to get announcement %s: %w",
+			announcementID, err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -887,8 +2437,8 @@ func (m *Manager) deleteAnnouncement(c *gin.Context) {
 	}
 	err = m.client.Delete(c.Request.Context(), news)
 	if err != nil {
-		err := fmt.Errorf("failed to delete announcement: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to delete announcement: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -902,8 +2452,8 @@ func (m *Manager) GetFile(c *gin.Context, fileID string) (*v1beta1.File, error)
 	file := new(v1beta1.File)
 	err := m.client.Get(c.Request.Context(), client.ObjectKey{Name: fileID}, file)
 	if err != nil {
-		err := fmt.Errorf("failed to get file: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to get file: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -917,7 +2467,9 @@ func (m *Manager) updateFile(c *gin.Context) {
 
 	oFile := new(v1beta1.File)
 	var nFile internal.FileBase
-	c.BindJSON(&nFile)
+	if !m.bindJSON(c, &nFile) {
+		return
+	}
 
 	var fileInfo []v1beta1.FileInfo
 	if nFile.Files != nil && len(nFile.Files) > 0 {
@@ -957,8 +2509,8 @@ func (m *Manager) updateFile(c *gin.Context) {
 	if file.Spec.Type != oFile.Spec.Type || file.Spec.Alias != oFile.Spec.Alias {
 		e := m.client.Patch(c.Request.Context(), &file, client.Apply, []client.PatchOption{client.ForceOwnership, client.FieldOwner("mirror-controller")}...)
 		if e != nil {
-			err := fmt.Errorf("failed to patch file %s info: %s",
-				fileID, e.Error(),
+			err := fmt.Errorf("failed to patch file %s info: %w",
+				fileID, e,
 			)
 			c.Error(err)
 			m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -966,8 +2518,8 @@ func (m *Manager) updateFile(c *gin.Context) {
 		}
 		if len(fileInfo) > 0 {
 			if e := m.client.Get(c.Request.Context(), client.ObjectKey{Name: fileID}, oFile); e != nil {
-				err := fmt.Errorf("failed to get file: %s",
-					e.Error(),
+				err := fmt.Errorf("failed to get file: %w",
+					e,
 				)
 				c.Error(err)
 				m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -978,12 +2530,12 @@ func (m *Manager) updateFile(c *gin.Context) {
 
 	if len(fileInfo) > 0 {
 		oFile.Status.Files = fileInfo
-		oFile.Status.UpdateTime = time.Now().Unix()
+		oFile.Status.UpdateTime = m.now().Unix()
 
 		e := m.client.Status().Update(c.Request.Context(), oFile)
 		if e != nil {
-			err := fmt.Errorf("failed to update file %s list: %s",
-				fileID, e.Error(),
+			err := fmt.Errorf("failed to update file %s list: %w",
+				fileID, e,
 			)
 			c.Error(err)
 			m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -1017,8 +2569,8 @@ func (m *Manager) listFile(c *gin.Context) {
 	})
 
 	if err != nil {
-		err := fmt.Errorf("failed to list files: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to list files: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -1035,8 +2587,8 @@ func (m *Manager) getFile(c *gin.Context) {
 
 	file, err := m.GetFile(c, fileID)
 	if err != nil {
-		err := fmt.Errorf("failed to get file %s: %s",
-			fileID, err.Error(),
+		err := fmt.Errorf("failed to get file %s: %w",
+			fileID, err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -1058,8 +2610,8 @@ func (m *Manager) deleteFile(c *gin.Context) {
 	}
 	err = m.client.Delete(c.Request.Context(), file)
 	if err != nil {
-		err := fmt.Errorf("failed to delete file: %s",
-			err.Error(),
+		err := fmt.Errorf("failed to delete file: %w",
+			err,
 		)
 		c.Error(err)
 		m.returnErrJSON(c, http.StatusInternalServerError, err)
@@ -1070,7 +2622,7 @@ func (m *Manager) deleteFile(c *gin.Context) {
 }
 
 func (m *Manager) mirrorZ(c *gin.Context) {
-	mirrorZ := m.option.MirrorZ
+	mirrorZ := m.options().MirrorZ
 	mirrorZ.Info = new([]mirrorz.Info)
 	mirrorZ.Mirrors = new([]mirrorz.Mirror)
 
@@ -1101,7 +2653,7 @@ func (m *Manager) mirrorZ(c *gin.Context) {
 			} else {
 				fullSize += v.Status.Size
 				disabled := false
-				url := v.Spec.Config.Url
+				url := publicURL(&v.Spec.Config)
 				if url == "" {
 					url = fmt.Sprintf("/%s", v.Name)
 				}
@@ -1127,7 +2679,7 @@ func (m *Manager) mirrorZ(c *gin.Context) {
 						if v.Status.LastEnded != 0 {
 							status = fmt.Sprintf("F%d", v.Status.LastEnded)
 						}
-					case v1beta1.Paused:
+					case v1beta1.Paused, v1beta1.Offline:
 						if v.Status.LastEnded != 0 {
 							status = fmt.Sprintf("P%d", v.Status.LastEnded)
 						}
@@ -1166,8 +2718,8 @@ func (m *Manager) mirrorZ(c *gin.Context) {
 	}
 
 	mirrorZ.Site.Disk = internal.ParseSize(fullSize)
-	if m.option.Total != "" {
-		mirrorZ.Site.Disk += "/" + m.option.Total
+	if m.options().Total != "" {
+		mirrorZ.Site.Disk += "/" + m.options().Total
 	}
 
 	if _, ok := c.GetQuery("pack"); ok {
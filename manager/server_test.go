@@ -0,0 +1,958 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newTestManager returns a manager keeping jobs in memory
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	m, err := GetTUNASyncManager(nil, Options{Scheme: scheme, Storage: StorageMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m.internal = ctx
+	m.waitForCache()
+	return m
+}
+
+// setOptions changes the options of m as a reload does, on a copy stored
+// whole
+func setOptions(m *Manager, change func(o *Options)) {
+	o := *m.options()
+	change(&o)
+	m.option.Store(&o)
+}
+
+// do serves a json request and returns the recorded response
+func do(m *Manager, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+	return w
+}
+
+// ackWorker acks every command posted to a worker
+type ackWorker struct{}
+
+func (ackWorker) RoundTrip(*http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"msg":"` + internal.CmdAckOK + `"}`)),
+	}, nil
+}
+
+// fakeClock is a Clock which only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func createTestJob(t *testing.T, m *Manager, name string) {
+	t.Helper()
+	w := do(m, http.MethodPost, "/job/"+name, `{"config":{"upstream":"rsync://example.com/`+name+`/","provider":"rsync"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create job %s: %d %s", name, w.Code, w.Body.String())
+	}
+}
+
+func getTestJob(t *testing.T, m *Manager, name string) *v1beta1.Job {
+	t.Helper()
+	job := new(v1beta1.Job)
+	if err := m.client.Get(context.Background(), client.ObjectKey{Name: name}, job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// TestUpdateMirrorSizeKeepsStatus interleaves size reports with status
+// writes of another writer, none of them may be lost
+func TestUpdateMirrorSizeKeepsStatus(t *testing.T) {
+	m := newTestManager(t)
+	createTestJob(t, m, "foo")
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= rounds; i++ {
+			w := do(m, http.MethodPost, "/job/foo/size", fmt.Sprintf(`{"size":%d}`, i))
+			if w.Code != http.StatusOK {
+				t.Errorf("size %d: %d %s", i, w.Code, w.Body.String())
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		// not serialized by the manager, as another replica would write
+		for i := 1; i <= rounds; i++ {
+			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				job := new(v1beta1.Job)
+				if err := m.client.Get(context.Background(), client.ObjectKey{Name: "foo"}, job); err != nil {
+					return err
+				}
+				job.Status.ErrorMsg = fmt.Sprintf("round %d", i)
+				return m.client.Status().Update(context.Background(), job)
+			})
+			if err != nil {
+				t.Errorf("status %d: %s", i, err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	job := getTestJob(t, m, "foo")
+	if job.Status.Size != rounds {
+		t.Errorf("size = %d, want %d", job.Status.Size, rounds)
+	}
+	if want := fmt.Sprintf("round %d", rounds); job.Status.ErrorMsg != want {
+		t.Errorf("error msg = %q, want %q", job.Status.ErrorMsg, want)
+	}
+}
+
+// TestUpdateMirrorSizeAfterStatus checks a size report doesn't roll back
+// a status reported just before
+func TestUpdateMirrorSizeAfterStatus(t *testing.T) {
+	m := newTestManager(t)
+	createTestJob(t, m, "foo")
+
+	for _, status := range []v1beta1.SyncStatus{v1beta1.PreSyncing, v1beta1.Syncing, v1beta1.Success} {
+		if w := do(m, http.MethodPatch, "/job/foo", `{"status":"`+string(status)+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("status %s: %d %s", status, w.Code, w.Body.String())
+		}
+		if w := do(m, http.MethodPost, "/job/foo/size", `{"size":42}`); w.Code != http.StatusOK {
+			t.Fatalf("size: %d %s", w.Code, w.Body.String())
+		}
+		if job := getTestJob(t, m, "foo"); job.Status.Status != status || job.Status.Size != 42 {
+			t.Errorf("status = %s size = %d, want %s and 42", job.Status.Status, job.Status.Size, status)
+		}
+	}
+}
+
+// racingStore makes every other status update of the manager race with
+// a write of another replica, which bumps the size just before
+type racingStore struct {
+	Store
+	updates, races *atomic.Int32
+}
+
+func (s racingStore) Status() client.SubResourceWriter {
+	return racingStatusWriter{s.Store.Status(), s}
+}
+
+type racingStatusWriter struct {
+	client.SubResourceWriter
+	s racingStore
+}
+
+func (w racingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if w.s.updates.Add(1)%2 == 1 {
+		job := new(v1beta1.Job)
+		if err := w.s.Store.Get(ctx, client.ObjectKeyFromObject(obj), job); err != nil {
+			return err
+		}
+		job.Status.Size++
+		if err := w.SubResourceWriter.Update(ctx, job); err != nil {
+			return err
+		}
+		w.s.races.Add(1)
+	}
+	return w.SubResourceWriter.Update(ctx, obj, opts...)
+}
+
+// TestClientCmdConcurrent fires stop commands and a disable at a syncing
+// mirror at once while other writes race with them, the disable must win
+// whatever the order and the other writes must not be lost
+func TestClientCmdConcurrent(t *testing.T) {
+	m := newTestManager(t)
+	m.cmdClient = &http.Client{Transport: ackWorker{}}
+	createTestJob(t, m, "foo")
+	for _, status := range []v1beta1.SyncStatus{v1beta1.PreSyncing, v1beta1.Syncing} {
+		if w := do(m, http.MethodPatch, "/job/foo", `{"status":"`+string(status)+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("status %s: %d %s", status, w.Code, w.Body.String())
+		}
+	}
+	races := new(atomic.Int32)
+	m.client = racingStore{m.client, new(atomic.Int32), races}
+
+	const cmds = 10
+	var wg sync.WaitGroup
+	for i := 0; i < cmds; i++ {
+		cmd := internal.CmdStop
+		if i == cmds/2 {
+			cmd = internal.CmdDisable
+		}
+		wg.Add(1)
+		go func(cmd internal.CmdVerb) {
+			defer wg.Done()
+			w := do(m, http.MethodPost, "/job/foo/cmd", `{"cmd":"`+cmd.String()+`"}`)
+			// a stop after the disable is illegal
+			if (w.Code != http.StatusOK && w.Code != http.StatusConflict) || w.Body.Len() == 0 {
+				t.Errorf("%s: %d %s", cmd, w.Code, w.Body.String())
+			}
+		}(cmd)
+	}
+	wg.Wait()
+
+	job := getTestJob(t, m, "foo")
+	if job.Status.Status != v1beta1.Disabled {
+		t.Errorf("status = %s, want %s", job.Status.Status, v1beta1.Disabled)
+	}
+	if job.Status.Size != uint64(races.Load()) {
+		t.Errorf("size = %d, want %d", job.Status.Size, races.Load())
+	}
+}
+
+// TestUpdateJobUnknownStatus checks a garbage status is rejected, or
+// stored as unknown when coerced, and never stored as it is
+func TestUpdateJobUnknownStatus(t *testing.T) {
+	m := newTestManager(t)
+	createTestJob(t, m, "foo")
+
+	if w := do(m, http.MethodPatch, "/job/foo", `{"status":"sücc3ss"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("reject: %d %s, want 400", w.Code, w.Body.String())
+	}
+	if job := getTestJob(t, m, "foo"); job.Status.Status != "" {
+		t.Errorf("status = %q after reject, want none", job.Status.Status)
+	}
+
+	setOptions(m, func(o *Options) { o.UnknownStatus = UnknownStatusCoerce })
+	if w := do(m, http.MethodPatch, "/job/foo", `{"status":"sücc3ss"}`); w.Code != http.StatusOK {
+		t.Fatalf("coerce: %d %s", w.Code, w.Body.String())
+	}
+	if job := getTestJob(t, m, "foo"); job.Status.Status != v1beta1.Unknown {
+		t.Errorf("status = %q after coerce, want %s", job.Status.Status, v1beta1.Unknown)
+	}
+
+	// a known status is taken again
+	if w := do(m, http.MethodPatch, "/job/foo", `{"status":"`+string(v1beta1.PreSyncing)+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("recover: %d %s", w.Code, w.Body.String())
+	}
+	if job := getTestJob(t, m, "foo"); job.Status.Status != v1beta1.PreSyncing {
+		t.Errorf("status = %q, want %s", job.Status.Status, v1beta1.PreSyncing)
+	}
+}
+
+// reportStatus reports status as sent at reportedAt, through a full or a
+// merge patch
+func reportStatus(t *testing.T, m *Manager, name string, status v1beta1.SyncStatus, reportedAt int64, merge bool) {
+	t.Helper()
+	body := fmt.Sprintf(`{"status":"%s","reportedAt":%d}`, status, reportedAt)
+	req := httptest.NewRequest(http.MethodPatch, "/job/"+name, strings.NewReader(body))
+	if merge {
+		req.Header.Set("Content-Type", mergePatchJSON)
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("report %s at %d: %d %s", status, reportedAt, w.Code, w.Body.String())
+	}
+}
+
+// TestUpdateJobOutOfOrder checks a status reported before the stored one
+// and arriving late is discarded by the timestamp policy, while the retry
+// policy stores the statuses in the order they arrive
+func TestUpdateJobOutOfOrder(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		merge  bool
+		want   v1beta1.SyncStatus
+	}{
+		{StatusConflictTimestamp, false, v1beta1.Syncing},
+		{StatusConflictTimestamp, true, v1beta1.Syncing},
+		{StatusConflictRetry, false, v1beta1.PreSyncing},
+		{"", true, v1beta1.PreSyncing},
+	} {
+		t.Run(fmt.Sprintf("%s/merge=%v", tc.policy, tc.merge), func(t *testing.T) {
+			m := newTestManager(t)
+			setOptions(m, func(o *Options) { o.StatusConflict = tc.policy })
+			createTestJob(t, m, "foo")
+
+			reportStatus(t, m, "foo", v1beta1.PreSyncing, 1000, tc.merge)
+			reportStatus(t, m, "foo", v1beta1.Syncing, 3000, tc.merge)
+			// a retry of a report sent in between arrives last
+			reportStatus(t, m, "foo", v1beta1.PreSyncing, 2000, tc.merge)
+
+			job := getTestJob(t, m, "foo")
+			if job.Status.Status != tc.want {
+				t.Errorf("status = %s, want %s", job.Status.Status, tc.want)
+			}
+			if tc.want == v1beta1.Syncing && job.Status.ReportedAt != 3000 {
+				t.Errorf("reportedAt = %d, want 3000", job.Status.ReportedAt)
+			}
+		})
+	}
+}
+
+// TestUpdateJobWithoutTimestamp checks a status reported without a time,
+// e.g. by an older worker, is always stored under the timestamp policy
+func TestUpdateJobWithoutTimestamp(t *testing.T) {
+	m := newTestManager(t)
+	setOptions(m, func(o *Options) { o.StatusConflict = StatusConflictTimestamp })
+	createTestJob(t, m, "foo")
+
+	reportStatus(t, m, "foo", v1beta1.PreSyncing, 2000, false)
+	if w := do(m, http.MethodPatch, "/job/foo", `{"status":"syncing"}`); w.Code != http.StatusOK {
+		t.Fatalf("report: %d %s", w.Code, w.Body.String())
+	}
+	job := getTestJob(t, m, "foo")
+	if job.Status.Status != v1beta1.Syncing || job.Status.ReportedAt != 2000 {
+		t.Errorf("status = %s reportedAt = %d, want syncing and 2000", job.Status.Status, job.Status.ReportedAt)
+	}
+}
+
+// TestUpdateJobTimestamps checks each report stamps only the times of its
+// status, and the others are kept from the stored status
+func TestUpdateJobTimestamps(t *testing.T) {
+	m := newTestManager(t)
+	clock := newFakeClock()
+	m.clock = clock
+	createTestJob(t, m, "foo")
+
+	report := func(status v1beta1.SyncStatus) *v1beta1.Job {
+		t.Helper()
+		if w := do(m, http.MethodPatch, "/job/foo", `{"status":"`+string(status)+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("report %s: %d %s", status, w.Code, w.Body.String())
+		}
+		return getTestJob(t, m, "foo")
+	}
+
+	started := clock.Now().Unix()
+	job := report(v1beta1.PreSyncing)
+	if job.Status.LastStarted != started || job.Status.LastOnline != started {
+		t.Errorf("presyncing: lastStarted = %d lastOnline = %d, want %d", job.Status.LastStarted, job.Status.LastOnline, started)
+	}
+
+	clock.Advance(time.Minute)
+	report(v1beta1.Syncing)
+	clock.Advance(time.Hour)
+	synced := clock.Now().Unix()
+	job = report(v1beta1.Success)
+	if job.Status.LastStarted != started {
+		t.Errorf("success: lastStarted = %d, want %d", job.Status.LastStarted, started)
+	}
+	if job.Status.LastUpdate != synced || job.Status.LastEnded != synced {
+		t.Errorf("success: lastUpdate = %d lastEnded = %d, want %d", job.Status.LastUpdate, job.Status.LastEnded, synced)
+	}
+
+	clock.Advance(time.Hour)
+	report(v1beta1.PreSyncing)
+	report(v1beta1.Syncing)
+	clock.Advance(time.Hour)
+	failed := clock.Now().Unix()
+	job = report(v1beta1.Failed)
+	if job.Status.LastUpdate != synced {
+		t.Errorf("failed: lastUpdate = %d, want %d", job.Status.LastUpdate, synced)
+	}
+	if job.Status.LastEnded != failed || job.Status.LastOnline != failed {
+		t.Errorf("failed: lastEnded = %d lastOnline = %d, want %d", job.Status.LastEnded, job.Status.LastOnline, failed)
+	}
+}
+
+// TestWorkerTokenNotInSpec checks the token hash can't be cleared or
+// read through the spec, which anyone may post and export
+func TestWorkerTokenNotInSpec(t *testing.T) {
+	m := newTestManager(t)
+	createTestJob(t, m, "foo")
+	if w := do(m, http.MethodPost, "/job/foo/token", ""); w.Code != http.StatusOK {
+		t.Fatalf("rotate token: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := do(m, http.MethodPost, "/job/foo", `{"config":{"tokenHash":""}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("post tokenHash: %d, want 400", w.Code)
+	}
+	if w := do(m, http.MethodPatch, "/job/foo", `{"status":"pre-syncing"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("report without token: %d, want 401", w.Code)
+	}
+	if w := do(m, http.MethodGet, "/export", ""); strings.Contains(w.Body.String(), getTestJob(t, m, "foo").Status.TokenHash) {
+		t.Errorf("export has the token hash: %s", w.Body.String())
+	}
+}
+
+// TestSignedCmd checks a command is accepted when signed with the command
+// secret, but not when its timestamp went stale or its nonce is reused
+func TestSignedCmd(t *testing.T) {
+	m := newTestManager(t)
+	m.cmdClient = &http.Client{Transport: ackWorker{}}
+	// SignRequest stamps the real time
+	clock := &fakeClock{now: time.Now()}
+	m.clock = clock
+	setOptions(m, func(o *Options) {
+		o.SignedCmds = true
+		o.CmdSecret = "secret"
+	})
+	createTestJob(t, m, "foo")
+
+	const body = `{"cmd":"ping"}`
+	post := func(key string, sign func(req *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/job/foo/cmd", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if err := internal.SignRequest(req, []byte(key), []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+		if sign != nil {
+			sign(req)
+		}
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	var nonce string
+	if w := post("secret", func(req *http.Request) { nonce = req.Header.Get(internal.NonceHeader) }); w.Code != http.StatusOK {
+		t.Fatalf("signed: %d %s", w.Code, w.Body.String())
+	}
+	if w := post("secret", nil); w.Code != http.StatusOK {
+		t.Errorf("signed again: %d %s", w.Code, w.Body.String())
+	}
+	if w := post("other", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong key: %d, want 401", w.Code)
+	}
+	if w := do(m, http.MethodPost, "/job/foo/cmd", body); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned: %d, want 401", w.Code)
+	}
+
+	// a replay of the first command, signed with the same nonce
+	replay := func(req *http.Request) {
+		timestamp := req.Header.Get(internal.TimestampHeader)
+		req.Header.Set(internal.NonceHeader, nonce)
+		req.Header.Set(internal.SignatureHeader, internal.SignCmd([]byte("secret"), req.Method, req.URL.Path, timestamp, nonce, []byte(body)))
+	}
+	if w := post("secret", replay); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), errNonceReused.Error()) {
+		t.Errorf("reused nonce: %d %s, want 401", w.Code, w.Body.String())
+	}
+
+	clock.Advance(2 * signatureWindow)
+	if w := post("secret", nil); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), errStaleSignature.Error()) {
+		t.Errorf("stale timestamp: %d %s, want 401", w.Code, w.Body.String())
+	}
+}
+
+// rbacStore forbids creating namespaces as the Role of the manager does,
+// and fails creating jobs as the api server does in a missing namespace
+type rbacStore struct {
+	Store
+	missing bool
+}
+
+func (s rbacStore) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*corev1.Namespace); ok {
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, obj.GetName(), errors.New("namespaced role"))
+	}
+	return s.Store.Create(ctx, obj, opts...)
+}
+
+func (s rbacStore) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if s.missing {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "mirrors")
+	}
+	return s.Store.Patch(ctx, obj, patch, opts...)
+}
+
+// TestEnsureNamespaceForbidden checks a manager not allowed to create
+// namespaces takes the namespace as existing, and answers 403 when it's
+// missing after all
+func TestEnsureNamespaceForbidden(t *testing.T) {
+	for _, tc := range []struct {
+		missing bool
+		want    int
+	}{
+		{false, http.StatusOK},
+		{true, http.StatusForbidden},
+	} {
+		t.Run(fmt.Sprintf("missing=%v", tc.missing), func(t *testing.T) {
+			m := newTestManager(t)
+			setOptions(m, func(o *Options) {
+				o.Storage = StorageKubernetes
+				o.EnsureNamespace = true
+			})
+			m.client = rbacStore{m.client, tc.missing}
+
+			w := do(m, http.MethodPost, "/job/foo", `{"config":{"upstream":"rsync://example.com/foo/","provider":"rsync"}}`)
+			if w.Code != tc.want {
+				t.Fatalf("create job: %d %s, want %d", w.Code, w.Body.String(), tc.want)
+			}
+			if tc.missing && !strings.Contains(w.Body.String(), errNamespaceForbidden.Error()) {
+				t.Errorf("error %s doesn't tell the manager may not create the namespace", w.Body.String())
+			}
+		})
+	}
+}
+
+// TestDependsOnMissing checks a job can't depend on a job which doesn't
+// exist, and a worker is told to wait for a dependency not synced yet
+func TestDependsOnMissing(t *testing.T) {
+	m := newTestManager(t)
+	createTestJob(t, m, "foo")
+
+	if w := do(m, http.MethodPost, "/job/bar", `{"config":{"upstream":"rsync://example.com/bar/","provider":"rsync","dependsOn":["nope"]}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("depends on a missing job: %d %s, want 400", w.Code, w.Body.String())
+	}
+	if w := do(m, http.MethodPost, "/job/bar", `{"config":{"upstream":"rsync://example.com/bar/","provider":"rsync","dependsOn":["foo"]}}`); w.Code != http.StatusOK {
+		t.Fatalf("depends on foo: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(m, http.MethodGet, "/job/bar/dependencies", ""); w.Code != http.StatusConflict {
+		t.Errorf("foo not synced: %d %s, want 409", w.Code, w.Body.String())
+	}
+	for _, status := range []v1beta1.SyncStatus{v1beta1.PreSyncing, v1beta1.Syncing, v1beta1.Success} {
+		reportStatus(t, m, "foo", status, 0, false)
+	}
+	if w := do(m, http.MethodGet, "/job/bar/dependencies", ""); w.Code != http.StatusOK {
+		t.Errorf("foo synced: %d %s, want 200", w.Code, w.Body.String())
+	}
+}
+
+func TestWatchInitialJobs(t *testing.T) {
+	m := newTestManager(t)
+	n := watchBufferSize + 10
+	for i := 0; i < n; i++ {
+		createTestJob(t, m, fmt.Sprintf("mirror%d", i))
+	}
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/jobs/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// every existing job is sent, more than the buffer holds
+	added := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for added < n && scanner.Scan() {
+		switch scanner.Text() {
+		case "event:" + jobAdded:
+			added++
+		case "event:overflow":
+			t.Fatalf("overflow after %d of %d jobs", added, n)
+		}
+	}
+	if added != n {
+		t.Fatalf("got %d of %d jobs: %v", added, n, scanner.Err())
+	}
+}
+
+func TestDefaultIntervalFollowed(t *testing.T) {
+	m := newTestManager(t)
+	createTestJob(t, m, "debian")
+	if interval := getTestJob(t, m, "debian").Spec.Config.Interval; interval != 0 {
+		t.Fatalf("interval %d stored in the spec", interval)
+	}
+
+	if w := do(m, http.MethodPut, "/config/default-interval", `{"interval":60}`); w.Code != http.StatusOK {
+		t.Fatalf("set default interval: %d %s", w.Code, w.Body.String())
+	}
+	w := do(m, http.MethodGet, "/job/debian/effective-config", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("effective config: %d %s", w.Code, w.Body.String())
+	}
+	var config internal.MirrorConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Config.Interval != 60 {
+		t.Fatalf("interval %d after changing the default to 60", config.Config.Interval)
+	}
+}
+
+func TestBoostExpiresOnClock(t *testing.T) {
+	m := newTestManager(t)
+	clock := newFakeClock()
+	m.clock = clock
+	createTestJob(t, m, "debian")
+
+	if w := do(m, http.MethodPost, "/job/debian/boost", `{"interval":30,"duration":"1h"}`); w.Code != http.StatusOK {
+		t.Fatalf("boost: %d %s", w.Code, w.Body.String())
+	}
+	if until := getTestJob(t, m, "debian").Status.BoostUntil; until != clock.Now().Add(time.Hour).Unix() {
+		t.Fatalf("boosted until %d, not an hour after the clock", until)
+	}
+
+	clock.Advance(30 * time.Minute)
+	m.expireBoosts(context.Background(), m.now())
+	if getTestJob(t, m, "debian").Status.BoostUntil == 0 {
+		t.Fatal("boost ended before its duration")
+	}
+	clock.Advance(time.Hour)
+	m.expireBoosts(context.Background(), m.now())
+	if status := getTestJob(t, m, "debian").Status; status.BoostUntil != 0 || status.BoostInterval != 0 {
+		t.Fatalf("boost kept after its duration: %d %d", status.BoostUntil, status.BoostInterval)
+	}
+}
+
+func TestEffectiveConfigWorkerDefaults(t *testing.T) {
+	m := newTestManager(t)
+	if w := do(m, http.MethodPost, "/job/debian", `{"config":{"upstream":"rsync://example.com/debian/"}}`); w.Code != http.StatusOK {
+		t.Fatalf("create job: %d %s", w.Code, w.Body.String())
+	}
+
+	w := do(m, http.MethodGet, "/job/debian/effective-config", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("effective config: %d %s", w.Code, w.Body.String())
+	}
+	var config internal.MirrorConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config
+	if cfg.Provider != internal.DefaultProvider || cfg.Concurrent != internal.DefaultConcurrent ||
+		cfg.Retry != internal.DefaultRetry || cfg.MirrorPath != "/data/debian" || cfg.Interval != defaultInterval {
+		t.Fatalf("worker defaults missing: %+v", cfg)
+	}
+}
+
+func TestUnavailableUntilCacheSynced(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	m, err := GetTUNASyncManager(nil, Options{Scheme: scheme, Storage: StorageMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m.internal = ctx
+
+	w := do(m, http.MethodGet, "/jobs", "")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get(retryAfterHeader) == "" {
+		t.Fatalf("jobs before the cache synced: %d %q", w.Code, w.Header().Get(retryAfterHeader))
+	}
+	if w := do(m, http.MethodGet, "/ping", ""); w.Code != http.StatusOK {
+		t.Fatalf("ping before the cache synced: %d", w.Code)
+	}
+
+	m.waitForCache()
+	if w := do(m, http.MethodGet, "/jobs", ""); w.Code != http.StatusOK {
+		t.Fatalf("jobs after the cache synced: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// downWorker fails every command posted to a worker
+type downWorker struct{}
+
+func (downWorker) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+// failingStore fails the next status updates while fail is positive
+type failingStore struct {
+	Store
+	fail *atomic.Int32
+}
+
+func (s failingStore) Status() client.SubResourceWriter {
+	return failingStatusWriter{s.Store.Status(), s.fail}
+}
+
+type failingStatusWriter struct {
+	client.SubResourceWriter
+	fail *atomic.Int32
+}
+
+func (w failingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if w.fail.Add(-1) >= 0 {
+		return errors.New("api server unavailable")
+	}
+	return w.SubResourceWriter.Update(ctx, obj, opts...)
+}
+
+// TestHeartbeatOfflineRetried checks a mirror failing to be marked
+// offline is marked on the next miss
+func TestHeartbeatOfflineRetried(t *testing.T) {
+	m := newTestManager(t)
+	setOptions(m, func(o *Options) {
+		o.OfflineAfterMisses = 2
+		o.CmdRetries = 0
+	})
+	m.cmdClient = &http.Client{Transport: downWorker{}}
+	createTestJob(t, m, "debian")
+	for _, status := range []v1beta1.SyncStatus{v1beta1.PreSyncing, v1beta1.Syncing, v1beta1.Success} {
+		reportStatus(t, m, "debian", status, 0, false)
+	}
+
+	fail := new(atomic.Int32)
+	fail.Store(1)
+	m.client = failingStore{m.client, fail}
+	for i := 0; i < 2; i++ {
+		m.checkHeartbeats(context.Background())
+	}
+	if status := getTestJob(t, m, "debian").Status.Status; status != v1beta1.Success {
+		t.Fatalf("status %s while the update failed", status)
+	}
+	m.checkHeartbeats(context.Background())
+	if status := getTestJob(t, m, "debian").Status.Status; status != v1beta1.Offline {
+		t.Fatalf("status %s after another miss, want offline", status)
+	}
+	if missed := m.heartbeats.snapshot()["debian"].Missed; missed != 0 {
+		t.Fatalf("%d misses kept after marking offline", missed)
+	}
+}
+
+func TestCallbackTimeoutBounded(t *testing.T) {
+	m := newTestManager(t)
+	w := do(m, http.MethodPost, "/job/debian", `{"config":{"upstream":"rsync://example.com/debian/","callbackTimeout":3600}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "callbackTimeout") {
+		t.Fatalf("callback timeout of an hour: %d %s", w.Code, w.Body.String())
+	}
+	w = do(m, http.MethodPost, "/job/debian", fmt.Sprintf(`{"config":{"upstream":"rsync://example.com/debian/","callbackTimeout":%d}}`, maxCallbackTimeout))
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback timeout of %ds: %d %s", maxCallbackTimeout, w.Code, w.Body.String())
+	}
+}
+
+// patchStatus merge patches the status of the job with body
+func patchStatus(t *testing.T, m *Manager, name, body string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPatch, "/job/"+name, strings.NewReader(body))
+	req.Header.Set("Content-Type", mergePatchJSON)
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch %s: %d %s", body, w.Code, w.Body.String())
+	}
+}
+
+// TestPatchJobFinishesStatus checks a status patched in is stamped and
+// counted as one put whole, and a patch leaving it out is not
+func TestPatchJobFinishesStatus(t *testing.T) {
+	m := newTestManager(t)
+	clock := newFakeClock()
+	m.clock = clock
+	setOptions(m, func(o *Options) { o.AutoPauseFailures = 2 })
+	createTestJob(t, m, "debian")
+
+	patchStatus(t, m, "debian", `{"status":"pre-syncing"}`)
+	started := clock.Now().Unix()
+	clock.Advance(time.Minute)
+	patchStatus(t, m, "debian", `{"status":"syncing"}`)
+	clock.Advance(time.Minute)
+	patchStatus(t, m, "debian", `{"status":"success","size":42}`)
+	ended := clock.Now().Unix()
+
+	status := getTestJob(t, m, "debian").Status
+	if status.LastStarted != started || status.LastUpdate != ended || status.LastEnded != ended {
+		t.Fatalf("times %d %d %d, want %d %d %d", status.LastStarted, status.LastUpdate, status.LastEnded, started, ended, ended)
+	}
+	if len(status.History) != 1 || status.History[0].Duration != ended-started {
+		t.Fatalf("history %+v, want a sync of %ds", status.History, ended-started)
+	}
+
+	clock.Advance(time.Minute)
+	patchStatus(t, m, "debian", `{"size":43}`)
+	if status := getTestJob(t, m, "debian").Status; len(status.History) != 1 || status.LastUpdate != ended {
+		t.Fatalf("a patch of the size counted as a sync: %+v", status)
+	}
+
+	for i := 0; i < 2; i++ {
+		patchStatus(t, m, "debian", `{"status":"pre-syncing"}`)
+		patchStatus(t, m, "debian", `{"status":"syncing"}`)
+		patchStatus(t, m, "debian", `{"status":"failed"}`)
+	}
+	if status := getTestJob(t, m, "debian").Status; status.Status != v1beta1.Paused || status.ConsecutiveFailures != 2 {
+		t.Fatalf("status %s after %d failures, want paused", status.Status, status.ConsecutiveFailures)
+	}
+}
+
+func TestDeleteJobRequiresAdmin(t *testing.T) {
+	m := newTestManager(t)
+	setOptions(m, func(o *Options) { o.AdminToken = "admin" })
+	createTestJob(t, m, "debian")
+
+	if w := do(m, http.MethodDelete, "/job/debian", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("delete without the admin token: %d %s", w.Code, w.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodDelete, "/job/debian", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete with the admin token: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// TestAssignCmd checks an assigned command pins the mirror to the node
+// running the fewest syncs, draining nodes left out
+func TestAssignCmd(t *testing.T) {
+	m := newTestManager(t)
+	m.cmdClient = &http.Client{Transport: ackWorker{}}
+	for name, node := range map[string]string{"debian": "node1", "ubuntu": "node1", "fedora": "node2", "arch": "node3"} {
+		body := `{"config":{"upstream":"rsync://example.com/` + name + `/"},"deploy":{"nodeName":"` + node + `"}}`
+		if w := do(m, http.MethodPost, "/job/"+name, body); w.Code != http.StatusOK {
+			t.Fatalf("create job %s: %d %s", name, w.Code, w.Body.String())
+		}
+	}
+	for _, name := range []string{"debian", "ubuntu"} {
+		for _, status := range []v1beta1.SyncStatus{v1beta1.PreSyncing, v1beta1.Syncing} {
+			reportStatus(t, m, name, status, 0, false)
+		}
+	}
+	m.draining.add("node3", 0)
+	createTestJob(t, m, "alpine")
+
+	w := do(m, http.MethodPost, "/jobs/cmd?assign=true&id=alpine", `{"cmd":"start"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("assign: %d %s", w.Code, w.Body.String())
+	}
+	var assignment internal.CmdAssignment
+	if err := json.Unmarshal(w.Body.Bytes(), &assignment); err != nil {
+		t.Fatal(err)
+	}
+	if assignment.Node != "node2" || assignment.Load != 0 || assignment.From != "" {
+		t.Fatalf("assigned %+v, want node2 without syncs", assignment)
+	}
+	if node := getTestJob(t, m, "alpine").Spec.Deploy.NodeName; node != "node2" {
+		t.Fatalf("pinned to %q, want node2", node)
+	}
+
+	if w := do(m, http.MethodPost, "/jobs/cmd?assign=true&id=alpine", `{"cmd":"stop"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("assign a stop: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetJobConfigMissing(t *testing.T) {
+	m := newTestManager(t)
+	w := do(m, http.MethodGet, "/job/debian/config", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("config of a missing job: %d %s", w.Code, w.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("not a single error body: %v %s", err, w.Body.String())
+	}
+}
+
+// TestClockOfMaintenanceAndUptime checks the maintenance window and the
+// uptime of a worker follow the clock of the manager
+func TestClockOfMaintenanceAndUptime(t *testing.T) {
+	m := newTestManager(t)
+	clock := newFakeClock()
+	m.clock = clock
+	createTestJob(t, m, "debian")
+
+	if w := do(m, http.MethodHead, "/job/debian", ""); w.Code != http.StatusOK {
+		t.Fatalf("register: %d", w.Code)
+	}
+	clock.Advance(time.Hour)
+	w := do(m, http.MethodGet, "/job/debian", "")
+	var status internal.MirrorStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Uptime != int64(time.Hour/time.Second) {
+		t.Fatalf("uptime %d after an hour", status.Uptime)
+	}
+
+	start := clock.Now().Add(time.Hour).Unix()
+	body := fmt.Sprintf(`{"start":%d,"end":%d}`, start, start+3600)
+	if w := do(m, http.MethodPost, "/maintenance", body); w.Code != http.StatusOK {
+		t.Fatalf("set maintenance: %d %s", w.Code, w.Body.String())
+	}
+	clock.Advance(90 * time.Minute)
+	if w := do(m, http.MethodPatch, "/job/debian", `{"status":"pre-syncing"}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("sync within the maintenance window: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// TestForceStatusIfMatch checks a forced status doesn't overwrite a
+// status reported since the job was read
+func TestForceStatusIfMatch(t *testing.T) {
+	m := newTestManager(t)
+	createTestJob(t, m, "debian")
+	etag := do(m, http.MethodGet, "/job/debian", "").Header().Get(etagHeader)
+	reportStatus(t, m, "debian", v1beta1.PreSyncing, 0, false)
+
+	force := func(etag string) int {
+		req := httptest.NewRequest(http.MethodPost, "/job/debian/status", strings.NewReader(`{"status":"failed","reason":"stuck"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag)
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := force(etag); code != http.StatusConflict {
+		t.Fatalf("force with a stale version: %d", code)
+	}
+	if status := getTestJob(t, m, "debian").Status.Status; status != v1beta1.PreSyncing {
+		t.Fatalf("status %s overwritten", status)
+	}
+	if code := force(do(m, http.MethodGet, "/job/debian", "").Header().Get(etagHeader)); code != http.StatusOK {
+		t.Fatalf("force with the current version: %d", code)
+	}
+}
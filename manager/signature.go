@@ -0,0 +1,143 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// signatureWindow is how far the timestamp of a signed command may be off
+// the clock of the manager, nonces are remembered as long
+const signatureWindow = 5 * time.Minute
+
+var (
+	errSignatureRequired = errors.New("signed command required")
+	errBadSignature      = errors.New("invalid signature")
+	errStaleSignature    = errors.New("stale signature timestamp")
+	errNonceReused       = errors.New("nonce already used")
+)
+
+// nonceCache remembers the nonces of the signed commands until their
+// timestamps go stale
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// use records nonce until expire, false is returned if it's seen already
+func (nc *nonceCache) use(nonce string, expire, now time.Time) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	for k, v := range nc.seen {
+		if !now.Before(v) {
+			delete(nc.seen, k)
+		}
+	}
+	if _, ok := nc.seen[nonce]; ok {
+		return false
+	}
+	nc.seen[nonce] = expire
+	return true
+}
+
+// validateSignedCmds checks there's a secret to verify signed commands
+// with. Nothing stored with the jobs may serve as one, the specs are
+// open to anyone.
+func validateSignedCmds(o *Options) error {
+	if o.SignedCmds && o.CmdSecret == "" {
+		return errors.New("signed commands require a command secret")
+	}
+	return nil
+}
+
+// verifySignature rejects command posts which are not signed by
+// internal.SignCmd when Options.SignedCmds is set, or which replay the
+// nonce of an earlier one. It guards the command channel where TLS alone
+// is not trusted, e.g. behind proxies terminating it.
+func (m *Manager) verifySignature(c *gin.Context) {
+	if !m.options().SignedCmds {
+		c.Next()
+		return
+	}
+
+	reject := func(err error) {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusUnauthorized, err)
+		c.Abort()
+	}
+
+	signature := c.GetHeader(internal.SignatureHeader)
+	timestamp := c.GetHeader(internal.TimestampHeader)
+	nonce := c.GetHeader(internal.NonceHeader)
+	if signature == "" || timestamp == "" || nonce == "" {
+		reject(errSignatureRequired)
+		return
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		reject(fmt.Errorf("invalid signature timestamp %q", timestamp))
+		return
+	}
+	now := m.now()
+	signed := time.Unix(ts, 0)
+	if signed.Before(now.Add(-signatureWindow)) || signed.After(now.Add(signatureWindow)) {
+		reject(errStaleSignature)
+		return
+	}
+
+	// limitBody has buffered the body already
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	want := internal.SignCmd([]byte(m.options().CmdSecret), c.Request.Method, c.Request.URL.Path, timestamp, nonce, body)
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		reject(errBadSignature)
+		return
+	}
+
+	// only nonces of valid signatures are remembered, so that forged
+	// requests can't fill the cache
+	if !m.nonces.use(nonce, signed.Add(signatureWindow), now) {
+		reject(errNonceReused)
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,141 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// stateKey is the key of the ConfigMap of Options.StateConfigMap holding
+// the persisted state
+const stateKey = "state.json"
+
+// persistedState is the in-memory state surviving a restart of the
+// manager, so that the mirrors marked offline are restored once their
+// workers answer a new manager, and the settings changed at runtime kept
+type persistedState struct {
+	Heartbeats map[string]heartbeatState `json:"heartbeats"`
+	// DefaultInterval as set at runtime, overriding the configured one
+	DefaultInterval int `json:"defaultInterval,omitempty"`
+	// Draining are the nodes being drained, with when they started to
+	Draining map[string]int64 `json:"draining,omitempty"`
+}
+
+// stateSaver remembers the state saved last, so that an unchanged one
+// isn't written again
+type stateSaver struct {
+	mu   sync.Mutex
+	last []byte
+}
+
+func (h *heartbeats) restore(state map[string]heartbeatState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for mirror, s := range state {
+		if s.Missed > 0 {
+			h.missed[mirror] = s.Missed
+		}
+		if s.Marked != "" {
+			h.marked[mirror] = s.Marked
+		}
+	}
+}
+
+// loadState restores the state saved by an earlier run, a missing or
+// unreadable one is logged and the manager starts afresh
+func (m *Manager) loadState(ctx context.Context) {
+	if m.options().StateConfigMap == "" {
+		return
+	}
+	cm := new(corev1.ConfigMap)
+	if err := m.apiReader.Get(ctx, client.ObjectKey{Name: m.options().StateConfigMap}, cm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			runLog.Error(err, fmt.Sprintf("Failed to load state from configmap %s: %s", m.options().StateConfigMap, err.Error()))
+		}
+		return
+	}
+	var state persistedState
+	if err := json.Unmarshal([]byte(cm.Data[stateKey]), &state); err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to load state from configmap %s: %s", m.options().StateConfigMap, err.Error()))
+		return
+	}
+	m.heartbeats.restore(state.Heartbeats)
+	if state.DefaultInterval != 0 && validateInterval(state.DefaultInterval) == nil {
+		m.defaultInterval.set(state.DefaultInterval)
+	}
+	for node, since := range state.Draining {
+		m.draining.add(node, since)
+	}
+	m.stateSaver.last = []byte(cm.Data[stateKey])
+	runLog.Info(fmt.Sprintf("Loaded the state of %d mirrors from configmap %s", len(state.Heartbeats), m.options().StateConfigMap))
+}
+
+// saveState writes the state to Options.StateConfigMap if it changed
+// since saved last, a failure is only logged as it's saved again later
+func (m *Manager) saveState(ctx context.Context) {
+	if m.options().StateConfigMap == "" || m.options().ReadOnly {
+		return
+	}
+	state := persistedState{Heartbeats: m.heartbeats.snapshot(), Draining: m.draining.snapshot()}
+	if interval := m.defaultInterval.get(); interval != m.options().DefaultInterval {
+		state.DefaultInterval = interval
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		runLog.Error(err, "Failed to marshal state")
+		return
+	}
+
+	m.stateSaver.mu.Lock()
+	defer m.stateSaver.mu.Unlock()
+	if bytes.Equal(data, m.stateSaver.last) {
+		return
+	}
+
+	cm := new(corev1.ConfigMap)
+	err = m.apiReader.Get(ctx, client.ObjectKey{Name: m.options().StateConfigMap}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: m.options().StateConfigMap},
+			Data:       map[string]string{stateKey: string(data)},
+		}
+		err = m.client.Create(ctx, cm)
+	case err == nil:
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[stateKey] = string(data)
+		err = m.client.Update(ctx, cm)
+	}
+	if err != nil {
+		runLog.Error(err, fmt.Sprintf("Failed to save state to configmap %s: %s", m.options().StateConfigMap, err.Error()))
+		return
+	}
+	m.stateSaver.last = data
+	runLog.V(1).Info("Saved state to configmap " + m.options().StateConfigMap)
+}
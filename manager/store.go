@@ -0,0 +1,521 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	// StorageKubernetes keeps the jobs as resources of the api server
+	StorageKubernetes = "kubernetes"
+	// StorageMemory keeps the jobs in memory, without an api server or
+	// the controller, so workers are run elsewhere. The jobs are lost
+	// on restart.
+	StorageMemory = "memory"
+)
+
+// namespace of the jobs kept in memory, unless set
+const memoryNamespace = "default"
+
+// Store persists the objects of the manager, the handlers use nothing
+// else of a client.Client
+type Store interface {
+	client.Reader
+	client.Writer
+	client.StatusClient
+}
+
+// memoryStore is the Store of StorageMemory, the objects are kept by
+// kind and key with the checks of the api server the manager relies on:
+// resource versions, the status subresource and merge patches
+type memoryStore struct {
+	scheme *runtime.Scheme
+	mapper meta.RESTMapper
+
+	mu      sync.Mutex
+	version uint64
+	objects map[schema.GroupVersionKind]map[types.NamespacedName]client.Object
+	// version of the last change of the jobs, a watch from before it
+	// misses events and has to list again
+	jobsChanged uint64
+	jobs        *watch.Broadcaster
+}
+
+func newMemoryStore(scheme *runtime.Scheme) (client.Client, cache.Cache) {
+	// everything the manager keeps is namespaced
+	mapper := meta.NewDefaultRESTMapper(scheme.PreferredVersionAllGroups())
+	for gvk := range scheme.AllKnownTypes() {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	s := &memoryStore{
+		scheme:  scheme,
+		mapper:  mapper,
+		objects: make(map[schema.GroupVersionKind]map[types.NamespacedName]client.Object),
+		jobs:    watch.NewBroadcaster(memoryWatchQueue, watch.WaitIfChannelFull),
+	}
+	return s, newMemoryCache(s)
+}
+
+// events queued for each watcher of the jobs
+const memoryWatchQueue = 100
+
+func (s *memoryStore) Scheme() *runtime.Scheme {
+	return s.scheme
+}
+
+func (s *memoryStore) RESTMapper() meta.RESTMapper {
+	return s.mapper
+}
+
+func (s *memoryStore) GroupVersionKindFor(obj runtime.Object) (schema.GroupVersionKind, error) {
+	return apiutil.GVKForObject(obj, s.scheme)
+}
+
+func (s *memoryStore) IsObjectNamespaced(runtime.Object) (bool, error) {
+	return true, nil
+}
+
+func (s *memoryStore) groupResource(gvk schema.GroupVersionKind) schema.GroupResource {
+	mapping, err := s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}
+	}
+	return mapping.Resource.GroupResource()
+}
+
+// lookup returns the kind of obj and the object kept under its key
+func (s *memoryStore) lookup(obj client.Object) (schema.GroupVersionKind, client.Object, error) {
+	gvk, err := s.GroupVersionKindFor(obj)
+	if err != nil {
+		return gvk, nil, err
+	}
+	cur, ok := s.objects[gvk][client.ObjectKeyFromObject(obj)]
+	if !ok {
+		return gvk, nil, apierrors.NewNotFound(s.groupResource(gvk), obj.GetName())
+	}
+	return gvk, cur, nil
+}
+
+// store keeps a copy of obj under a new resource version, and hands the
+// version back to obj
+func (s *memoryStore) store(gvk schema.GroupVersionKind, obj client.Object, event watch.EventType) {
+	s.version++
+	obj.SetResourceVersion(strconv.FormatUint(s.version, 10))
+	if s.objects[gvk] == nil {
+		s.objects[gvk] = make(map[types.NamespacedName]client.Object)
+	}
+	kept := obj.DeepCopyObject().(client.Object)
+	s.objects[gvk][client.ObjectKeyFromObject(obj)] = kept
+	s.notify(event, kept)
+}
+
+func (s *memoryStore) notify(event watch.EventType, obj client.Object) {
+	if _, ok := obj.(*v1beta1.Job); !ok {
+		return
+	}
+	s.jobsChanged = s.version
+	_ = s.jobs.Action(event, obj.DeepCopyObject())
+}
+
+// copyInto sets dst to a copy of src, both of the same type
+func copyInto(dst, src client.Object) {
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src.DeepCopyObject()).Elem())
+}
+
+// hasStatus tells the kinds whose status is only written through the
+// status subresource
+func hasStatus(obj client.Object) bool {
+	switch obj.(type) {
+	case *v1beta1.Job, *v1beta1.Announcement, *v1beta1.File:
+		return true
+	}
+	return false
+}
+
+// copyStatus sets the status of dst to the status of src
+func copyStatus(dst, src client.Object) {
+	reflect.ValueOf(dst).Elem().FieldByName("Status").Set(
+		reflect.ValueOf(src.DeepCopyObject()).Elem().FieldByName("Status"))
+}
+
+func (s *memoryStore) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj.SetNamespace(key.Namespace)
+	obj.SetName(key.Name)
+	_, cur, err := s.lookup(obj)
+	if err != nil {
+		return err
+	}
+	copyInto(obj, cur)
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	o := new(client.ListOptions)
+	o.ApplyOptions(opts)
+	if o.FieldSelector != nil && !o.FieldSelector.Empty() {
+		return errors.New("field selectors are not supported in memory")
+	}
+	gvk, err := s.GroupVersionKindFor(list)
+	if err != nil {
+		return err
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]types.NamespacedName, 0, len(s.objects[gvk]))
+	for key, obj := range s.objects[gvk] {
+		if o.Namespace != "" && key.Namespace != o.Namespace {
+			continue
+		}
+		if o.LabelSelector != nil && !o.LabelSelector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Namespace != keys[j].Namespace {
+			return keys[i].Namespace < keys[j].Namespace
+		}
+		return keys[i].Name < keys[j].Name
+	})
+	items := make([]runtime.Object, len(keys))
+	for i, key := range keys {
+		items[i] = s.objects[gvk][key].DeepCopyObject()
+	}
+	if err = meta.SetList(list, items); err != nil {
+		return err
+	}
+	list.SetResourceVersion(strconv.FormatUint(s.version, 10))
+	return nil
+}
+
+// Create drops events, nobody reads them without an api server
+func (s *memoryStore) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	if _, ok := obj.(*corev1.Event); ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(obj)
+}
+
+func (s *memoryStore) create(obj client.Object) error {
+	if obj.GetResourceVersion() != "" {
+		return apierrors.NewBadRequest("resourceVersion should not be set on objects to be created")
+	}
+	if obj.GetName() == "" && obj.GetGenerateName() != "" {
+		obj.SetName(obj.GetGenerateName() + utilrand.String(5))
+	}
+	gvk, _, err := s.lookup(obj)
+	if err == nil {
+		return apierrors.NewAlreadyExists(s.groupResource(gvk), obj.GetName())
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	obj.SetUID(uuid.NewUUID())
+	obj.SetCreationTimestamp(metav1.Now())
+	s.store(gvk, obj, watch.Added)
+	return nil
+}
+
+// checkVersion fails like the api server when obj was read before the
+// last change of cur, an object without a version is written anyway
+func (s *memoryStore) checkVersion(gvk schema.GroupVersionKind, obj, cur client.Object) error {
+	if v := obj.GetResourceVersion(); v != "" && v != cur.GetResourceVersion() {
+		return apierrors.NewConflict(s.groupResource(gvk), obj.GetName(),
+			errors.New("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+	return nil
+}
+
+// keepMeta carries over the metadata the api server sets itself
+func keepMeta(obj, cur client.Object) {
+	obj.SetUID(cur.GetUID())
+	obj.SetCreationTimestamp(cur.GetCreationTimestamp())
+}
+
+func (s *memoryStore) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(obj)
+}
+
+func (s *memoryStore) update(obj client.Object) error {
+	gvk, cur, err := s.lookup(obj)
+	if err != nil {
+		return err
+	}
+	if err = s.checkVersion(gvk, obj, cur); err != nil {
+		return err
+	}
+	keepMeta(obj, cur)
+	if hasStatus(obj) {
+		copyStatus(obj, cur)
+	}
+	s.store(gvk, obj, watch.Modified)
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gvk, cur, err := s.lookup(obj)
+	if err != nil {
+		return err
+	}
+	delete(s.objects[gvk], client.ObjectKeyFromObject(obj))
+	s.version++
+	s.notify(watch.Deleted, cur)
+	return nil
+}
+
+func (s *memoryStore) DeleteAllOf(context.Context, client.Object, ...client.DeleteAllOfOption) error {
+	return errors.New("delete collection is not supported in memory")
+}
+
+// Patch applies a whole object by creating or updating it, the only kind
+// of apply made by the manager, other patches are merge patches
+func (s *memoryStore) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if patch.Type() != types.ApplyPatchType {
+		return s.patch(obj, patch, false)
+	}
+
+	_, cur, err := s.lookup(obj)
+	if apierrors.IsNotFound(err) {
+		return s.create(obj)
+	}
+	if err != nil {
+		return err
+	}
+	// the status is kept by the update, a resourceVersion given is a
+	// precondition as for the api server
+	if obj.GetResourceVersion() == "" {
+		obj.SetResourceVersion(cur.GetResourceVersion())
+	}
+	return s.update(obj)
+}
+
+// patch merges patch into the object kept for obj, only into its status
+// if status is set, or only outside of it otherwise
+func (s *memoryStore) patch(obj client.Object, patch client.Patch, status bool) error {
+	if patch.Type() != types.MergePatchType {
+		return fmt.Errorf("%s patches are not supported in memory", patch.Type())
+	}
+	gvk, cur, err := s.lookup(obj)
+	if err != nil {
+		return err
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	orig, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+	merged, err := jsonpatch.MergePatch(orig, data)
+	if err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+	patched := reflect.New(reflect.TypeOf(cur).Elem()).Interface().(client.Object)
+	if err = json.Unmarshal(merged, patched); err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+	// a version in the patch is a precondition
+	if err = s.checkVersion(gvk, patched, cur); err != nil {
+		return err
+	}
+
+	next := patched
+	switch {
+	case hasStatus(cur) && status:
+		next = cur.DeepCopyObject().(client.Object)
+		copyStatus(next, patched)
+	case hasStatus(cur):
+		copyStatus(next, cur)
+	}
+	keepMeta(next, cur)
+	s.store(gvk, next, watch.Modified)
+	copyInto(obj, next)
+	return nil
+}
+
+func (s *memoryStore) updateStatus(obj client.Object) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gvk, cur, err := s.lookup(obj)
+	if err != nil {
+		return err
+	}
+	if err = s.checkVersion(gvk, obj, cur); err != nil {
+		return err
+	}
+	next := cur.DeepCopyObject().(client.Object)
+	copyStatus(next, obj)
+	s.store(gvk, next, watch.Modified)
+	copyInto(obj, next)
+	return nil
+}
+
+// watchJobs watches the jobs changed after version, which has to be the
+// version of a list or of an event
+func (s *memoryStore) watchJobs(version string) (watch.Interface, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, err := strconv.ParseUint(version, 10, 64); err != nil || v < s.jobsChanged {
+		return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %s", version))
+	}
+	return s.jobs.Watch()
+}
+
+func (s *memoryStore) Status() client.SubResourceWriter {
+	return s.SubResource("status")
+}
+
+func (s *memoryStore) SubResource(subResource string) client.SubResourceClient {
+	return memorySubResource{s, subResource}
+}
+
+// memorySubResource writes the status of a memoryStore, other
+// subresources are not kept in memory
+type memorySubResource struct {
+	s    *memoryStore
+	name string
+}
+
+func (r memorySubResource) supported() error {
+	if r.name != "status" {
+		return fmt.Errorf("subresource %s is not supported in memory", r.name)
+	}
+	return nil
+}
+
+func (r memorySubResource) Get(ctx context.Context, obj, subResource client.Object, _ ...client.SubResourceGetOption) error {
+	if err := r.supported(); err != nil {
+		return err
+	}
+	return r.s.Get(ctx, client.ObjectKeyFromObject(obj), subResource)
+}
+
+func (r memorySubResource) Create(context.Context, client.Object, client.Object, ...client.SubResourceCreateOption) error {
+	return fmt.Errorf("creating subresource %s is not supported in memory", r.name)
+}
+
+func (r memorySubResource) Update(_ context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+	if err := r.supported(); err != nil {
+		return err
+	}
+	return r.s.updateStatus(obj)
+}
+
+func (r memorySubResource) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.SubResourcePatchOption) error {
+	if err := r.supported(); err != nil {
+		return err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.patch(obj, patch, true)
+}
+
+// memoryCache serves the job informer of a memoryStore, reads go to the
+// store directly
+type memoryCache struct {
+	client.Reader
+	informer toolscache.SharedIndexInformer
+}
+
+func newMemoryCache(s *memoryStore) *memoryCache {
+	lw := &toolscache.ListWatch{
+		ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+			jobs := new(v1beta1.JobList)
+			return jobs, s.List(context.Background(), jobs)
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			return s.watchJobs(o.ResourceVersion)
+		},
+	}
+	return &memoryCache{
+		Reader:   s,
+		informer: toolscache.NewSharedIndexInformer(lw, &v1beta1.Job{}, defaultRetryPeriod, toolscache.Indexers{}),
+	}
+}
+
+func (mc *memoryCache) GetInformer(_ context.Context, obj client.Object, _ ...cache.InformerGetOption) (cache.Informer, error) {
+	if _, ok := obj.(*v1beta1.Job); !ok {
+		return nil, fmt.Errorf("only jobs are watched in memory, not %T", obj)
+	}
+	return mc.informer, nil
+}
+
+func (mc *memoryCache) GetInformerForKind(_ context.Context, gvk schema.GroupVersionKind, _ ...cache.InformerGetOption) (cache.Informer, error) {
+	if gvk != v1beta1.GroupVersion.WithKind("Job") {
+		return nil, fmt.Errorf("only jobs are watched in memory, not %s", gvk)
+	}
+	return mc.informer, nil
+}
+
+func (mc *memoryCache) RemoveInformer(context.Context, client.Object) error {
+	return nil
+}
+
+// Start runs the informer until ctx is done
+func (mc *memoryCache) Start(ctx context.Context) error {
+	mc.informer.Run(ctx.Done())
+	return nil
+}
+
+func (mc *memoryCache) WaitForCacheSync(ctx context.Context) bool {
+	return toolscache.WaitForCacheSync(ctx.Done(), mc.informer.HasSynced)
+}
+
+func (mc *memoryCache) IndexField(context.Context, client.Object, string, client.IndexerFunc) error {
+	return errors.New("field indexes are not supported in memory")
+}
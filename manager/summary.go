@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// summarizeJob responds with the count of mirrors by status and their
+// total size, the stale count takes ?threshold as /jobs/stale does
+func (m *Manager) summarizeJob(c *gin.Context) {
+	threshold, err := time.ParseDuration(c.DefaultQuery("threshold", defaultStaleThreshold))
+	if err != nil || threshold <= 0 {
+		err := fmt.Errorf("invalid threshold %s", c.Query("threshold"))
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	opts, ok := m.listOptions(c)
+	if !ok {
+		return
+	}
+
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	jobs := new(v1beta1.JobList)
+	if err = m.client.List(c.Request.Context(), jobs, opts...); err != nil {
+		err := fmt.Errorf("failed to list mirrors: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := m.now()
+	summary := internal.JobSummary{Statuses: map[v1beta1.SyncStatus]int{}}
+	// every status is present, so that the dashboard needn't check
+	for _, st := range internal.SyncStatuses {
+		summary.Statuses[st] = 0
+	}
+	for _, v := range jobs.Items {
+		if v.Spec.Config.Type == v1beta1.External {
+			continue
+		}
+		w := mirrorStatus(&v, now)
+		summary.Total++
+		summary.Statuses[w.Status]++
+		summary.Size += w.Size
+		summary.DiskSize += w.DiskSize
+		summary.CompressedSize += w.CompressedSize
+		summary.Usage += usage(&v.Status)
+		if _, stale := staleMirror(&v, now, threshold); stale {
+			summary.Stale++
+		}
+	}
+	summary.SizeStr = internal.ParseSize(summary.Size)
+	summary.DiskSizeStr = internal.ParseSize(summary.DiskSize)
+	summary.CompressedSizeStr = internal.ParseSize(summary.CompressedSize)
+	summary.UsageStr = internal.ParseSize(summary.Usage)
+	c.JSON(http.StatusOK, summary)
+}
+
+// usage is the storage the mirror consumes, as exact as it's reported
+func usage(status *v1beta1.JobStatus) uint64 {
+	switch {
+	case status.DiskSize > 0:
+		return status.DiskSize
+	case status.CompressedSize > 0:
+		return status.CompressedSize
+	default:
+		return status.Size
+	}
+}
+
+// validateCompression checks a size update carries the compressed size
+// or the compression ratio, not both
+func validateCompression(size *uint64, ratio *float64) error {
+	if size != nil && ratio != nil {
+		return errors.New("compressedSize and compressionRatio are exclusive")
+	}
+	if ratio != nil && !(*ratio > 0) {
+		return fmt.Errorf("invalid compression ratio %v", *ratio)
+	}
+	return nil
+}
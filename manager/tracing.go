@@ -0,0 +1,168 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	tracerName     = "github.com/CQUPTMirror/kubesync/manager"
+	tracingService = "kubesync-manager"
+)
+
+// setupTracing exports spans to the otlp http endpoint, e.g.
+// http://otel-collector:4318, and accepts w3c trace context from callers
+func setupTracing(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing endpoint %s: %w", endpoint, err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracingService))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp, nil
+}
+
+// traceRequest starts a server span for every request, continuing the
+// trace of the caller if any
+func traceRequest(tracer trace.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("client.address", c.ClientIP()),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, c.Errors.String())
+		}
+	}
+}
+
+// endSpan records err on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingClient wraps every call to the api server in a child span of
+// the request
+type tracingClient struct {
+	Store
+	tracer trace.Tracer
+}
+
+func (t tracingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	ctx, span := t.tracer.Start(ctx, "client get", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("key", key.String())))
+	err := t.Store.Get(ctx, key, obj, opts...)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	ctx, span := t.tracer.Start(ctx, "client list", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("type", fmt.Sprintf("%T", list))))
+	err := t.Store.List(ctx, list, opts...)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	ctx, span := t.tracer.Start(ctx, "client create", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("name", obj.GetName())))
+	err := t.Store.Create(ctx, obj, opts...)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	ctx, span := t.tracer.Start(ctx, "client delete", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("name", obj.GetName())))
+	err := t.Store.Delete(ctx, obj, opts...)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	ctx, span := t.tracer.Start(ctx, "client update", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("name", obj.GetName())))
+	err := t.Store.Update(ctx, obj, opts...)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, span := t.tracer.Start(ctx, "client patch", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("name", obj.GetName()), attribute.String("patch", string(patch.Type()))))
+	err := t.Store.Patch(ctx, obj, patch, opts...)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracingClient) Status() client.SubResourceWriter {
+	return tracingStatusWriter{t.Store.Status(), t.tracer}
+}
+
+type tracingStatusWriter struct {
+	client.SubResourceWriter
+	tracer trace.Tracer
+}
+
+func (t tracingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	ctx, span := t.tracer.Start(ctx, "client status update", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("name", obj.GetName())))
+	err := t.SubResourceWriter.Update(ctx, obj, opts...)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	ctx, span := t.tracer.Start(ctx, "client status patch", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("name", obj.GetName()), attribute.String("patch", string(patch.Type()))))
+	err := t.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,172 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// what is done to a status reported which isn't known, see
+// Options.UnknownStatus
+const (
+	// UnknownStatusReject responds 400, the default
+	UnknownStatusReject = "reject"
+	// UnknownStatusCoerce stores it as v1beta1.Unknown
+	UnknownStatusCoerce = "coerce"
+)
+
+// statusTransitions are the legal status changes of a job:
+//
+//	none/created/success/failed/paused -> pre-syncing -> syncing -> success/failed
+//	failed -> syncing, when the worker retries
+//	syncing -> pre-syncing, when the worker restarts
+//	any but disabled -> paused, on stop
+//	any but paused/disabled -> offline, on worker shutdown
+//	offline -> pre-syncing/failed, when the worker is back
+//	offline -> former status, when the worker answers heartbeats again
+//	any -> disabled, on disable
+//	none/created/paused/disabled -> created, on enable
+//	any -> unknown, on a status reported which isn't known
+//	unknown -> any, when the worker reports a known status again
+//
+// Reporting the current status again is always legal. Paused and disabled
+// jobs don't sync until they are started or enabled again.
+var statusTransitions = map[v1beta1.SyncStatus][]v1beta1.SyncStatus{
+	v1beta1.None:       {v1beta1.PreSyncing, v1beta1.Paused, v1beta1.Disabled, v1beta1.Created, v1beta1.Offline},
+	v1beta1.Created:    {v1beta1.PreSyncing, v1beta1.Paused, v1beta1.Disabled, v1beta1.Offline},
+	v1beta1.Cached:     {v1beta1.PreSyncing, v1beta1.Paused, v1beta1.Disabled, v1beta1.Created, v1beta1.Offline},
+	v1beta1.PreSyncing: {v1beta1.Syncing, v1beta1.Failed, v1beta1.Paused, v1beta1.Disabled, v1beta1.Offline},
+	v1beta1.Syncing:    {v1beta1.Success, v1beta1.Failed, v1beta1.PreSyncing, v1beta1.Paused, v1beta1.Disabled, v1beta1.Offline},
+	v1beta1.Success:    {v1beta1.PreSyncing, v1beta1.Paused, v1beta1.Disabled, v1beta1.Offline},
+	v1beta1.Failed:     {v1beta1.PreSyncing, v1beta1.Syncing, v1beta1.Paused, v1beta1.Disabled, v1beta1.Offline},
+	v1beta1.Paused:     {v1beta1.PreSyncing, v1beta1.Disabled, v1beta1.Created},
+	v1beta1.Disabled:   {v1beta1.Created},
+	v1beta1.Offline:    {v1beta1.PreSyncing, v1beta1.Failed, v1beta1.Paused, v1beta1.Disabled, v1beta1.Created},
+	v1beta1.Unknown: {
+		v1beta1.None, v1beta1.Failed, v1beta1.Success, v1beta1.Syncing, v1beta1.PreSyncing,
+		v1beta1.Paused, v1beta1.Disabled, v1beta1.Cached, v1beta1.Created, v1beta1.Offline,
+	},
+}
+
+type transitionError struct {
+	from, to v1beta1.SyncStatus
+}
+
+func (e *transitionError) Error() string {
+	return fmt.Sprintf("illegal status transition from %s to %s", e.from, e.to)
+}
+
+// checkStatusTransition returns a *transitionError if from can't become to
+func checkStatusTransition(from, to v1beta1.SyncStatus) error {
+	if from == "" {
+		from = v1beta1.None
+	}
+	if from == to || to == v1beta1.Unknown {
+		return nil
+	}
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return nil
+		}
+	}
+	return &transitionError{from: from, to: to}
+}
+
+// applyStatusTransition sets the status if the transition is legal
+func applyStatusTransition(status *v1beta1.JobStatus, to v1beta1.SyncStatus) error {
+	if err := checkStatusTransition(status.Status, to); err != nil {
+		return err
+	}
+	status.Status = to
+	return nil
+}
+
+// startsSync tells whether the transition starts a new sync
+func startsSync(from, to v1beta1.SyncStatus) bool {
+	switch from {
+	case v1beta1.PreSyncing, v1beta1.Syncing:
+		return false
+	}
+	return to == v1beta1.PreSyncing || to == v1beta1.Syncing
+}
+
+// how a status reported concurrently with another write is resolved, see
+// Options.StatusConflict
+const (
+	// StatusConflictRetry stores the statuses in the order they arrive,
+	// and fails a write racing with another, the default
+	StatusConflictRetry = "retry"
+	// StatusConflictTimestamp stores the status reported last by
+	// ReportedAt, one reported before the stored one arriving late is
+	// discarded
+	StatusConflictTimestamp = "timestamp"
+)
+
+// validateConflictOptions checks the options on statuses reported
+func validateConflictOptions(o *Options) error {
+	switch o.UnknownStatus {
+	case "", UnknownStatusReject, UnknownStatusCoerce:
+	default:
+		return fmt.Errorf("invalid unknown status %s, must be %s or %s", o.UnknownStatus, UnknownStatusReject, UnknownStatusCoerce)
+	}
+	switch o.StatusConflict {
+	case "", StatusConflictRetry, StatusConflictTimestamp:
+	default:
+		return fmt.Errorf("invalid status conflict %s, must be %s or %s", o.StatusConflict, StatusConflictRetry, StatusConflictTimestamp)
+	}
+	return nil
+}
+
+// staleReport tells whether status was reported before the stored one and
+// is to be discarded, a status without ReportedAt is never stale
+func (m *Manager) staleReport(stored, status *v1beta1.JobStatus) bool {
+	if m.options().StatusConflict != StatusConflictTimestamp {
+		return false
+	}
+	return status.ReportedAt != 0 && status.ReportedAt < stored.ReportedAt
+}
+
+// discardReport responds with the stored status in place of a stale one,
+// so the worker learns what's stored without retrying
+func (m *Manager) discardReport(c *gin.Context, job *v1beta1.Job, status v1beta1.SyncStatus) {
+	runLog.Info(fmt.Sprintf("Job [%s] discarded %s reported before the stored %s", job.Name, status, job.Status.Status))
+	c.JSON(http.StatusOK, job.Status)
+}
+
+// checkReportedStatus rejects or coerces a status reported by a worker
+// which isn't known, as set by Options.UnknownStatus. An empty status is
+// left to the caller.
+func (m *Manager) checkReportedStatus(c *gin.Context, mirrorID string, status *v1beta1.SyncStatus) bool {
+	if *status == "" || internal.IsSyncStatus(*status) {
+		return true
+	}
+	if m.options().UnknownStatus == UnknownStatusCoerce {
+		runLog.Info(fmt.Sprintf("Mirror <%s> reported unknown status %q, stored as %s", mirrorID, *status, v1beta1.Unknown))
+		*status = v1beta1.Unknown
+		return true
+	}
+	err := fmt.Errorf("unknown status %q of job %s", *status, mirrorID)
+	c.Error(err)
+	m.returnErrJSON(c, http.StatusBadRequest, err)
+	return false
+}
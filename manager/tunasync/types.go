@@ -0,0 +1,60 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunasync
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebMirrorStatus is how the manager of tunasync lists mirrors
+type WebMirrorStatus struct {
+	Name          string    `json:"name"`
+	IsMaster      bool      `json:"is_master"`
+	Status        string    `json:"status"`
+	LastUpdate    TextTime  `json:"last_update"`
+	LastUpdateTs  StampTime `json:"last_update_ts"`
+	LastStarted   TextTime  `json:"last_started"`
+	LastStartedTs StampTime `json:"last_started_ts"`
+	LastEnded     TextTime  `json:"last_ended"`
+	LastEndedTs   StampTime `json:"last_ended_ts"`
+	Scheduled     TextTime  `json:"next_schedule"`
+	ScheduledTs   StampTime `json:"next_schedule_ts"`
+	Upstream      string    `json:"upstream"`
+	Size          string    `json:"size"`
+}
+
+// TextTime is a time as text, e.g. 2006-01-02 15:04:05 -0700
+type TextTime struct {
+	time.Time
+}
+
+// MarshalJSON formats the time in its own zone as tunasync does
+func (t TextTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Format("2006-01-02 15:04:05 -0700"))
+}
+
+// StampTime is a time as unix seconds
+type StampTime struct {
+	time.Time
+}
+
+// MarshalJSON writes the unix seconds as a json number
+func (t StampTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Unix())
+}
@@ -0,0 +1,164 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// warningHeader carries what went wrong in a request that still succeeded,
+// the same as the api server of kubernetes, e.g. 299 - "text"
+const warningHeader = "Warning"
+
+// addWarning adds a warning to the response, for results only partial
+func addWarning(c *gin.Context, text string) {
+	c.Writer.Header().Add(warningHeader, "299 - "+strconv.Quote(text))
+}
+
+// jsonFields returns the json keys of a struct type, including the ones
+// promoted from embedded structs
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			for k := range jsonFields(f.Type) {
+				fields[k] = true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		fields[tag] = true
+	}
+	return fields
+}
+
+// projectFields keeps only the requested json keys of each item
+func projectFields[T any](items []T, fields []string) ([]map[string]interface{}, error) {
+	valid := jsonFields(reflect.TypeOf(*new(T)))
+	for _, f := range fields {
+		if !valid[f] {
+			return nil, fmt.Errorf("unknown field: %s", f)
+		}
+	}
+
+	projected := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var full map[string]interface{}
+		if err = json.Unmarshal(b, &full); err != nil {
+			return nil, err
+		}
+		p := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			if v, ok := full[f]; ok {
+				p[f] = v
+			}
+		}
+		projected = append(projected, p)
+	}
+	return projected, nil
+}
+
+// timestampKeys are the json keys of unix seconds in responses
+var timestampKeys = map[string]bool{
+	"lastUpdate": true, "lastStarted": true, "lastEnded": true, "nextSchedule": true,
+	"lastOnline": true, "lastRegister": true, "nextRetry": true, "boostUntil": true,
+	"created": true, "time": true, "first": true, "upstreamTime": true,
+}
+
+// withTimes adds an RFC3339 string next to every non-zero timestamp of v,
+// e.g. lastUpdateTime next to lastUpdate, for clients whose numbers lose
+// the precision of int64
+func withTimes(v interface{}, loc *time.Location) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var full interface{}
+	if err = dec.Decode(&full); err != nil {
+		return nil, err
+	}
+	addTimes(full, loc)
+	return full, nil
+}
+
+func addTimes(v interface{}, loc *time.Location) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			n, ok := item.(json.Number)
+			if !ok || !timestampKeys[k] {
+				addTimes(item, loc)
+				continue
+			}
+			if sec, err := n.Int64(); err == nil && sec > 0 {
+				v[k+"Time"] = time.Unix(sec, 0).In(loc).Format(time.RFC3339)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			addTimes(item, loc)
+		}
+	}
+}
+
+// jittered stretches period by a random part of up to jitter of it, so
+// that the loops of several managers drift apart
+func jittered(period time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return period
+	}
+	return period + time.Duration(rand.Float64()*jitter*float64(period))
+}
+
+// runEvery calls f every period, jittered by Options.LoopJitter, until ctx
+// is done, the first call is one period away
+func (m *Manager) runEvery(ctx context.Context, period time.Duration, f func()) {
+	for {
+		timer := time.NewTimer(jittered(period, m.options().LoopJitter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			f()
+		}
+	}
+}
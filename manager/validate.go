@@ -0,0 +1,180 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// interval is in minutes, at most 30 days
+	minInterval = 1
+	maxInterval = 30 * 24 * 60
+
+	// a worker running late may report a schedule just passed
+	scheduleGrace    = time.Hour
+	maxScheduleAhead = 365 * 24 * time.Hour
+
+	// seconds, a few times the default CmdTimeout, a command is acked at
+	// once by a healthy worker and a longer wait only holds the client
+	maxCallbackTimeout = 30
+)
+
+type fieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+func toFieldErrors(errs field.ErrorList) []fieldError {
+	fe := make([]fieldError, 0, len(errs))
+	for _, e := range errs {
+		fe = append(fe, fieldError{Field: e.Field, Detail: e.ErrorBody()})
+	}
+	return fe
+}
+
+// validateJobSpec checks a job spec before it is persisted
+func validateJobSpec(spec *v1beta1.JobSpec) field.ErrorList {
+	var errs field.ErrorList
+	cfg := field.NewPath("config")
+
+	switch spec.Config.Type {
+	case "", v1beta1.Mirror, v1beta1.Proxy, v1beta1.Git:
+	case v1beta1.External:
+		if spec.Config.Provider == "" {
+			errs = append(errs, field.Required(cfg.Child("provider"), "external mirrors need a provider"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(cfg.Child("type"), spec.Config.Type,
+			[]string{string(v1beta1.Mirror), string(v1beta1.Proxy), string(v1beta1.Git), string(v1beta1.External)}))
+	}
+
+	if spec.Config.Upstream == "" {
+		errs = append(errs, field.Required(cfg.Child("upstream"), ""))
+	} else if u, err := url.Parse(spec.Config.Upstream); err != nil || u.Scheme == "" {
+		errs = append(errs, field.Invalid(cfg.Child("upstream"), spec.Config.Upstream, "must be an absolute url"))
+	}
+
+	if spec.Config.Url != "" {
+		if _, err := url.Parse(spec.Config.Url); err != nil {
+			errs = append(errs, field.Invalid(cfg.Child("url"), spec.Config.Url, err.Error()))
+		}
+	}
+	if marker := spec.Config.UpstreamMarker; marker != "" {
+		if u, err := url.Parse(marker); err != nil {
+			errs = append(errs, field.Invalid(cfg.Child("upstreamMarker"), marker, err.Error()))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, field.Invalid(cfg.Child("upstreamMarker"), marker, "must be an http or https url"))
+		} else if err = checkMarkerHost(spec.Config.Upstream, u); err != nil {
+			errs = append(errs, field.Invalid(cfg.Child("upstreamMarker"), marker, err.Error()))
+		}
+	}
+	if spec.Config.PublicURL != "" {
+		if _, err := url.Parse(spec.Config.PublicURL); err != nil {
+			errs = append(errs, field.Invalid(cfg.Child("publicUrl"), spec.Config.PublicURL, err.Error()))
+		}
+	}
+	if spec.Config.HelpUrl != "" {
+		if _, err := url.Parse(spec.Config.HelpUrl); err != nil {
+			errs = append(errs, field.Invalid(cfg.Child("helpUrl"), spec.Config.HelpUrl, err.Error()))
+		}
+	}
+
+	// zero means using the worker default
+	if spec.Config.Interval != 0 && (spec.Config.Interval < minInterval || spec.Config.Interval > maxInterval) {
+		errs = append(errs, field.Invalid(cfg.Child("interval"), spec.Config.Interval, "must be between 1 and 43200 minutes"))
+	}
+	if spec.Config.Concurrent < 0 {
+		errs = append(errs, field.Invalid(cfg.Child("concurrent"), spec.Config.Concurrent, "must not be negative"))
+	}
+	if spec.Config.Retry < 0 {
+		errs = append(errs, field.Invalid(cfg.Child("retry"), spec.Config.Retry, "must not be negative"))
+	}
+	if spec.Config.Timeout < 0 {
+		errs = append(errs, field.Invalid(cfg.Child("timeout"), spec.Config.Timeout, "must not be negative"))
+	}
+	if spec.Config.RetryInterval < 0 {
+		errs = append(errs, field.Invalid(cfg.Child("retryInterval"), spec.Config.RetryInterval, "must not be negative"))
+	}
+	if spec.Config.MaxRetries < 0 {
+		errs = append(errs, field.Invalid(cfg.Child("maxRetries"), spec.Config.MaxRetries, "must not be negative"))
+	}
+	if spec.Config.BandwidthLimit < 0 {
+		errs = append(errs, field.Invalid(cfg.Child("bandwidthLimit"), spec.Config.BandwidthLimit, "must not be negative"))
+	}
+	if spec.Config.CallbackTimeout < 0 || spec.Config.CallbackTimeout > maxCallbackTimeout {
+		errs = append(errs, field.Invalid(cfg.Child("callbackTimeout"), spec.Config.CallbackTimeout,
+			fmt.Sprintf("must be between 0 and %d seconds", maxCallbackTimeout)))
+	}
+
+	if spec.Config.Provider == "command" && strings.TrimSpace(spec.Config.Command) == "" {
+		errs = append(errs, field.Required(cfg.Child("command"), "the command provider needs a command"))
+	}
+	if len(spec.Config.Args) > 0 && spec.Config.Provider != "command" {
+		errs = append(errs, field.Forbidden(cfg.Child("args"), "only for the command provider"))
+	}
+	for i, arg := range spec.Config.Args {
+		// passed to the worker separated by ;
+		if strings.Contains(arg, ";") {
+			errs = append(errs, field.Invalid(cfg.Child("args").Index(i), arg, "must not contain ;"))
+		}
+	}
+
+	if spec.Config.SyncAt != "" {
+		for _, at := range strings.Split(spec.Config.SyncAt, ";") {
+			if _, err := time.Parse(internal.ClockLayout, at); err != nil {
+				errs = append(errs, field.Invalid(cfg.Child("syncAt"), spec.Config.SyncAt, "must be clock times like 03:00 separated by ;"))
+				break
+			}
+		}
+	}
+	if spec.Config.SyncJitter < 0 {
+		errs = append(errs, field.Invalid(cfg.Child("syncJitter"), spec.Config.SyncJitter, "must not be negative"))
+	}
+
+	// parsed as is by the controller for the volume claim
+	if spec.Volume.Size != "" {
+		if _, err := resource.ParseQuantity(spec.Volume.Size); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("volume", "size"), spec.Volume.Size, err.Error()))
+		}
+	}
+
+	return errs
+}
+
+// validateSchedule rejects a next schedule far in the past or future,
+// zero means none is scheduled
+func validateSchedule(next int64, now time.Time) error {
+	if next == 0 {
+		return nil
+	}
+	t := time.Unix(next, 0)
+	if t.Before(now.Add(-scheduleGrace)) || t.After(now.Add(maxScheduleAhead)) {
+		return fmt.Errorf("next schedule %s must be within %s ago and %s ahead",
+			t.Format(time.RFC3339), scheduleGrace, maxScheduleAhead)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// Build info, set with -ldflags "-X github.com/CQUPTMirror/kubesync/manager.Version=..."
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+func getVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, internal.VersionInfo{
+		Version:    Version,
+		GitCommit:  GitCommit,
+		BuildDate:  BuildDate,
+		GoVersion:  runtime.Version(),
+		APIVersion: v1beta1.GroupVersion.String(),
+	})
+}
+
+// getCapabilities responds with the api version, commands and features,
+// queried by workers at startup
+func (m *Manager) getCapabilities(c *gin.Context) {
+	c.JSON(http.StatusOK, internal.Capabilities{
+		APIVersion: v1beta1.GroupVersion.String(),
+		Commands:   internal.CmdVerbs,
+		Features: internal.Features{
+			AdminAuth:    m.options().AdminToken != "",
+			WorkerTokens: true,
+			GracefulStop: true,
+			Heartbeats:   m.options().HeartbeatInterval.Duration > 0,
+			SignedCmds:   m.options().SignedCmds,
+			ReadOnly:     m.options().ReadOnly,
+		},
+	})
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (C) 2023  CQUPTMirror
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/CQUPTMirror/kubesync/api/v1beta1"
+	"github.com/CQUPTMirror/kubesync/internal"
+	"github.com/gin-gonic/gin"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	watchBufferSize   = 256
+	watchPingInterval = 15 * time.Second
+)
+
+const (
+	jobAdded    = "added"
+	jobModified = "modified"
+	jobDeleted  = "deleted"
+)
+
+type jobEvent struct {
+	Type   string
+	Mirror internal.MirrorStatus
+}
+
+func eventJob(obj interface{}) (*v1beta1.Job, bool) {
+	if d, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	job, ok := obj.(*v1beta1.Job)
+	if !ok || job.Spec.Config.Type == v1beta1.External {
+		return nil, false
+	}
+	return job, true
+}
+
+// watchJob streams job status changes as server-sent events, starting
+// with an "added" event for every existing job
+func (m *Manager) watchJob(c *gin.Context) {
+	ctx := c.Request.Context()
+	informer, err := m.cache.GetInformer(ctx, &v1beta1.Job{})
+	if err != nil {
+		err := fmt.Errorf("failed to watch jobs: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the informer adds every existing job on registration, before the
+	// stream starts, so there's room for them on top of the buffer
+	jobs := new(v1beta1.JobList)
+	if err := m.client.List(ctx, jobs); err != nil {
+		err := fmt.Errorf("failed to watch jobs: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// a client which can't keep up is disconnected, and gets a
+	// fresh snapshot when it reconnects
+	var overflow atomic.Bool
+	events := make(chan jobEvent, len(jobs.Items)+watchBufferSize)
+	send := func(t string, obj interface{}) {
+		job, ok := eventJob(obj)
+		if !ok {
+			return
+		}
+		select {
+		case events <- jobEvent{Type: t, Mirror: mirrorStatus(job, m.now())}:
+		default:
+			overflow.Store(true)
+		}
+	}
+
+	reg, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { send(jobAdded, obj) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			o, ok := eventJob(oldObj)
+			n, nok := eventJob(newObj)
+			// skip periodic resyncs
+			if ok && nok && o.ResourceVersion == n.ResourceVersion {
+				return
+			}
+			send(jobModified, newObj)
+		},
+		DeleteFunc: func(obj interface{}) { send(jobDeleted, obj) },
+	})
+	if err != nil {
+		err := fmt.Errorf("failed to watch jobs: %w", err)
+		c.Error(err)
+		m.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer informer.RemoveEventHandler(reg)
+
+	// the stream outlives the server write timeout
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		runLog.Info(fmt.Sprintf("Failed to clear write deadline of watch: %s", err.Error()))
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ping := time.NewTicker(watchPingInterval)
+	defer ping.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e := <-events:
+			if overflow.Load() {
+				c.SSEvent("overflow", gin.H{_errorKey: "too many pending events"})
+				return false
+			}
+			c.SSEvent(e.Type, e.Mirror)
+			return true
+		case <-ping.C:
+			c.SSEvent("ping", gin.H{})
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
@@ -12,6 +12,7 @@ import (
 type cmdConfig struct {
 	name                        string
 	upstreamURL, command        string
+	args                        []string
 	workingDir, logDir, logFile string
 	interval                    time.Duration
 	retry                       int
@@ -53,7 +54,10 @@ func newCmdProvider(c cmdConfig) (*cmdProvider, error) {
 	if err != nil {
 		return nil, err
 	}
-	provider.command = cmd
+	provider.command = append(cmd, c.args...)
+	if len(provider.command) == 0 {
+		return nil, errors.New("command required")
+	}
 	if len(c.failOnMatch) > 0 {
 		var err error
 		failOnMatch, err := regexp.Compile(c.failOnMatch)
@@ -3,11 +3,12 @@ package worker
 // put global variables and types here
 
 import (
+	"github.com/CQUPTMirror/kubesync/internal"
 	"gopkg.in/op/go-logging.v1"
 )
 
 type empty struct{}
 
-const defaultMaxRetry = 2
+const defaultMaxRetry = internal.DefaultRetry
 
 var logger = logging.MustGetLogger("tunasync")
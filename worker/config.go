@@ -2,6 +2,8 @@ package worker
 
 import (
 	"errors"
+
+	"github.com/CQUPTMirror/kubesync/internal"
 	"github.com/docker/go-units"
 )
 
@@ -29,12 +31,23 @@ type Config struct {
 	RsyncOptions  []string `toml:"rsync_options"`
 	RsyncOverride []string `toml:"rsync_override"`
 	Stage1Profile string   `toml:"stage1_profile"`
+	// BandwidthLimit is in bytes per second, passed to rsync as --bwlimit
+	BandwidthLimit int64 `toml:"bandwidth_limit"`
+	// SyncAt are the clock times, e.g. 03:00, to sync at instead of
+	// every Interval
+	SyncAt []string `toml:"sync_at"`
+	// SyncJitter is the max seconds a scheduled sync is delayed
+	SyncJitter int `toml:"sync_jitter"`
+	// Args are appended to Command without splitting
+	Args []string `toml:"args"`
 
 	ExecOnSuccess []string `toml:"exec_on_success"`
 	ExecOnFailure []string `toml:"exec_on_failure"`
 
 	APIBase string `toml:"api_base"`
 	Addr    string `toml:"listen_addr"`
+	// Token authenticates the worker to the manager
+	Token string `toml:"token"`
 
 	ZFSEnable bool   `toml:"zfs_enable"`
 	Zpool     string `toml:"zpool"`
@@ -80,22 +93,24 @@ func LoadConfig() (*Config, error) {
 	cfg.Debug = GetBoolEnv("DEBUG")
 
 	cfg.Name = GetStringEnv("NAME", "")
-	cfg.Provider = GetStringEnv("PROVIDER", "rsync")
+	cfg.Provider = GetStringEnv("PROVIDER", internal.DefaultProvider)
 	cfg.Upstream = GetStringEnv("UPSTREAM", "")
 	cfg.LogDir = GetStringEnv("LOG_DIR", "/var/log")
-	cfg.MirrorDir = GetStringEnv("MIRROR_DIR", "/data")
+	cfg.MirrorDir = GetStringEnv("MIRROR_DIR", internal.DefaultMirrorDir)
 	cfg.MirrorPath = GetStringEnv("MIRROR_PATH", "")
 
 	if cfg.Name == "" || cfg.Provider == "" || cfg.Upstream == "" {
 		return cfg, errors.New("failed to get mirror config")
 	}
 
-	cfg.Concurrent = GetIntEnv("CONCURRENT", 3)
-	cfg.Interval = GetIntEnv("INTERVAL", 1440)
+	cfg.Concurrent = GetIntEnv("CONCURRENT", internal.DefaultConcurrent)
+	// zero follows the default interval of the manager
+	cfg.Interval = GetIntEnv("INTERVAL", 0)
 	cfg.Retry = GetIntEnv("RETRY", 0)
 	cfg.Timeout = GetIntEnv("TIMEOUT", 0)
 
 	cfg.Command = GetStringEnv("COMMAND", "")
+	cfg.Args = GetListEnv("ARGS")
 	cfg.FailOnMatch = GetStringEnv("FAIL_ON_MATCH", "")
 	cfg.SizePattern = GetStringEnv("SIZE_PATTERN", "")
 	cfg.UseIPv6 = GetBoolEnv("IPV6")
@@ -105,13 +120,17 @@ func LoadConfig() (*Config, error) {
 	cfg.RsyncTimeout = GetIntEnv("RSYNC_TIMEOUT", 0)
 	cfg.RsyncOptions = GetListEnv("RSYNC_OPTIONS")
 	cfg.RsyncOverride = GetListEnv("RSYNC_OVERRIDE")
+	cfg.BandwidthLimit = int64(GetIntEnv("BANDWIDTH_LIMIT", 0))
 	cfg.Stage1Profile = GetStringEnv("STAGE1_PROFILE", "")
+	cfg.SyncAt = GetListEnv("SYNC_AT")
+	cfg.SyncJitter = GetIntEnv("SYNC_JITTER", 0)
 
 	cfg.ExecOnSuccess = GetListEnv("EXEC_ON_SUCCESS")
 	cfg.ExecOnFailure = GetListEnv("EXEC_ON_FAILURE")
 
 	cfg.APIBase = GetStringEnv("API", "http://manager:3000")
 	cfg.Addr = GetStringEnv("ADDR", ":6000")
+	cfg.Token = GetStringEnv("TOKEN", "")
 
 	cfg.ZFSEnable = GetBoolEnv("ZFS")
 	cfg.Zpool = GetStringEnv("ZPOOL", "")
@@ -83,6 +83,7 @@ func newMirrorProvider(cfg *Config) mirrorProvider {
 			name:        cfg.Name,
 			upstreamURL: cfg.Upstream,
 			command:     cfg.Command,
+			args:        cfg.Args,
 			workingDir:  mirrorDir,
 			failOnMatch: cfg.FailOnMatch,
 			sizePattern: cfg.SizePattern,
@@ -106,6 +107,7 @@ func newMirrorProvider(cfg *Config) mirrorProvider {
 			extraOptions:      cfg.RsyncOptions,
 			rsyncNeverTimeout: cfg.RsyncNoTimeo,
 			rsyncTimeoutValue: cfg.RsyncTimeout,
+			bandwidthLimit:    cfg.BandwidthLimit,
 			overriddenOptions: cfg.RsyncOverride,
 			workingDir:        mirrorDir,
 			logDir:            logDir,
@@ -131,6 +133,7 @@ func newMirrorProvider(cfg *Config) mirrorProvider {
 			extraOptions:      cfg.RsyncOptions,
 			rsyncNeverTimeout: cfg.RsyncNoTimeo,
 			rsyncTimeoutValue: cfg.RsyncTimeout,
+			bandwidthLimit:    cfg.BandwidthLimit,
 			workingDir:        mirrorDir,
 			logDir:            logDir,
 			logFile:           filepath.Join(logDir, "latest.log"),
@@ -15,6 +15,7 @@ type rsyncConfig struct {
 	overriddenOptions           []string
 	rsyncNeverTimeout           bool
 	rsyncTimeoutValue           int
+	bandwidthLimit              int64
 	workingDir, logDir, logFile string
 	useIPv6, useIPv4            bool
 	interval                    time.Duration
@@ -80,6 +81,9 @@ func newRsyncProvider(c rsyncConfig) (*rsyncProvider, error) {
 	if c.excludeFile != "" {
 		options = append(options, "--exclude-from", c.excludeFile)
 	}
+	if c.bandwidthLimit > 0 {
+		options = append(options, bwLimitOption(c.bandwidthLimit))
+	}
 	if c.extraOptions != nil {
 		options = append(options, c.extraOptions...)
 	}
@@ -145,3 +149,13 @@ func (p *rsyncProvider) Start() error {
 	logger.Debugf("set isRunning to true: %s", p.Name())
 	return nil
 }
+
+// bwLimitOption converts bytes per second to the KiB per second of
+// --bwlimit, where zero would mean no limit
+func bwLimitOption(bytesPerSec int64) string {
+	kib := bytesPerSec / 1024
+	if kib < 1 {
+		kib = 1
+	}
+	return fmt.Sprintf("--bwlimit=%d", kib)
+}
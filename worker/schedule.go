@@ -3,8 +3,11 @@ package worker
 // schedule queue for jobs
 
 import (
+	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/CQUPTMirror/kubesync/internal"
 )
 
 type schedule struct {
@@ -65,3 +68,42 @@ func (q *schedule) Remove() {
 	q.sched = false
 	return
 }
+
+// nextSync returns when to sync after from, the first of the clock times
+// of cfg.SyncAt or the interval after from, randomly delayed by up to
+// cfg.SyncJitter seconds
+func (w *Worker) nextSync(from time.Time) time.Time {
+	interval := w.job.provider.Interval()
+	if interval == 0 {
+		interval = w.defaultInterval()
+	}
+	next := from.Add(interval)
+	if at, ok := nextClockTime(w.cfg.SyncAt, from); ok {
+		next = at
+	}
+	if w.cfg.SyncJitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(w.cfg.SyncJitter))) * time.Second)
+	}
+	return next
+}
+
+// nextClockTime returns the first of the clock times after from, false
+// when none is valid
+func nextClockTime(clocks []string, from time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, c := range clocks {
+		t, err := time.Parse(internal.ClockLayout, c)
+		if err != nil {
+			logger.Errorf("Invalid sync time %s: %s", c, err.Error())
+			continue
+		}
+		at := time.Date(from.Year(), from.Month(), from.Day(), t.Hour(), t.Minute(), 0, 0, from.Location())
+		if !at.After(from) {
+			at = at.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next, !next.IsZero()
+}
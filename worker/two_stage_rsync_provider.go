@@ -15,6 +15,7 @@ type twoStageRsyncConfig struct {
 	extraOptions                []string
 	rsyncNeverTimeout           bool
 	rsyncTimeoutValue           int
+	bandwidthLimit              int64
 	workingDir, logDir, logFile string
 	useIPv6, useIPv4            bool
 	interval                    time.Duration
@@ -128,6 +129,9 @@ func (p *twoStageRsyncProvider) Options(stage int) ([]string, error) {
 	if p.excludeFile != "" {
 		options = append(options, "--exclude-from", p.excludeFile)
 	}
+	if p.bandwidthLimit > 0 {
+		options = append(options, bwLimitOption(p.bandwidthLimit))
+	}
 
 	return options, nil
 }
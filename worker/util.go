@@ -77,6 +77,9 @@ func (w *Worker) HandleRequest(method, url string, obj interface{}) (*http.Respo
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.cfg.Token)
+	}
 	return w.httpClient.Do(req)
 }
 
@@ -110,6 +113,27 @@ func FindAllSubmatchInFile(fileName string, re *regexp.Regexp) (matches [][][]by
 	return
 }
 
+// readFileTail reads at most the last n bytes of given file
+func readFileTail(fileName string, n int64) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.Size() > n {
+		if _, err = f.Seek(-n, io.SeekEnd); err != nil {
+			return "", err
+		}
+	}
+	content, err := io.ReadAll(f)
+	return string(content), err
+}
+
 // ExtractSizeFromLog uses a regexp to extract the size from log files
 func ExtractSizeFromLog(logFile string, re *regexp.Regexp) uint64 {
 	matches, _ := FindAllSubmatchInFile(logFile, re)
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/CQUPTMirror/kubesync/api/v1beta1"
 	"github.com/CQUPTMirror/kubesync/internal"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -26,6 +28,10 @@ type Worker struct {
 	schedule   *schedule
 	httpEngine *gin.Engine
 	httpClient *http.Client
+
+	// set by a graceful stop, the running sync pauses the job once it
+	// finishes instead of scheduling the next
+	stopAfterRun atomic.Bool
 }
 
 // NewTUNASyncWorker creates a worker
@@ -55,6 +61,7 @@ func NewTUNASyncWorker(cfg *Config) *Worker {
 
 // Run runs worker forever
 func (w *Worker) Run() {
+	w.checkCapabilities()
 	w.registerWorker()
 	go w.runHTTPServer()
 	w.runSchedule()
@@ -90,15 +97,22 @@ func (w *Worker) makeHTTPServer() {
 		var cmd internal.ClientCmd
 
 		if err := c.BindJSON(&cmd); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid request"})
+			c.JSON(http.StatusBadRequest, internal.CmdAck{Msg: "Invalid request"})
 			return
 		}
 
 		logger.Noticef("Received command: %+v", cmd)
 
+		// a ping from the manager only checks the worker is alive
+		if cmd.Cmd == internal.CmdPing {
+			c.JSON(http.StatusOK, internal.CmdAck{Msg: internal.CmdAckOK})
+			return
+		}
+
 		// No matter what command, the existing job
 		// schedule should be flushed
 		w.schedule.Remove()
+		w.stopAfterRun.Store(false)
 
 		// if job disabled, start them first
 		switch cmd.Cmd {
@@ -117,19 +131,26 @@ func (w *Worker) makeHTTPServer() {
 		case internal.CmdRestart:
 			w.job.ctrlChan <- jobRestart
 		case internal.CmdStop:
+			// the sync keeps running, see runSchedule
+			if cmd.Mode == internal.StopGraceful {
+				w.stopAfterRun.Store(true)
+				break
+			}
 			// if job is disabled, no goroutine would be there
 			// receiving this signal
 			if w.job.State() != stateDisabled {
 				w.job.ctrlChan <- jobStop
 			}
-		case internal.CmdPing:
-			// empty
+		case internal.CmdDisable:
+			if w.job.State() != stateDisabled {
+				w.job.ctrlChan <- jobDisable
+			}
 		default:
-			c.JSON(http.StatusNotAcceptable, gin.H{"msg": "Invalid Command"})
+			c.JSON(http.StatusNotAcceptable, internal.CmdAck{Msg: "Invalid Command"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"msg": "OK"})
+		c.JSON(http.StatusOK, internal.CmdAck{Msg: internal.CmdAckOK})
 	})
 	s.GET("/log", func(c *gin.Context) {
 		logger.Noticef("Return latest log")
@@ -177,9 +198,9 @@ func (w *Worker) runSchedule() {
 	default:
 		w.job.SetState(stateNone)
 		go w.job.Run(w.managerChan, w.semaphore)
-		stime := mirror.LastUpdate + int64(w.job.provider.Interval().Seconds())
+		stime := w.nextSync(time.Unix(mirror.LastUpdate, 0))
 		// logger.Debugf("Scheduling job %s @%s", w.job.Name(), stime.Format("2006-01-02 15:04:05"))
-		w.schedule.AddJob(stime, w.job)
+		w.schedule.AddJob(stime.Unix(), w.job)
 	}
 
 	w.L.Unlock()
@@ -196,17 +217,47 @@ func (w *Worker) runSchedule() {
 				logger.Infof("Job %s state is not ready, skip adding new schedule", w.Name())
 				continue
 			}
+			if w.job.State() == stateHalting && jobMsg.status == v1beta1.Failed {
+				// killed by the halt, reported as offline instead
+				continue
+			}
 
 			// syncing status is only meaningful when job
 			// is running. If it's paused or disabled
 			// a sync failure signal would be emitted
 			// which needs to be ignored
-			w.updateStatus(w.job, jobMsg)
+			status := w.updateStatus(w.job, jobMsg)
+
+			// the manager pauses a job failing too many times
+			if jobMsg.status == v1beta1.Failed && status.Status == v1beta1.Paused {
+				logger.Noticef("Job %s is paused by the manager", w.Name())
+				w.job.ctrlChan <- jobStop
+				continue
+			}
+
+			// stopped gracefully, the finished sync is the last one
+			if jobMsg.schedule && w.stopAfterRun.CompareAndSwap(true, false) {
+				logger.Noticef("Job %s is paused after the sync finished", w.Name())
+				w.job.ctrlChan <- jobStop
+				w.updateStatus(w.job, jobMessage{status: v1beta1.Paused})
+				continue
+			}
 
 			// only successful or the final failure msg
 			// can trigger scheduling
 			if jobMsg.schedule {
-				schedTime := time.Now().Add(w.job.provider.Interval())
+				now := time.Now()
+				schedTime := w.nextSync(now)
+				// a boosted job syncs more often until the boost ends
+				if status.BoostUntil > now.Unix() && status.BoostInterval > 0 {
+					if boosted := now.Add(time.Duration(status.BoostInterval) * time.Minute); boosted.Before(schedTime) {
+						schedTime = boosted
+					}
+				}
+				// the manager recommends an earlier retry of failures
+				if jobMsg.status == v1beta1.Failed && status.NextRetry > 0 && status.NextRetry < schedTime.Unix() {
+					schedTime = time.Unix(status.NextRetry, 0)
+				}
 				logger.Noticef(
 					"Next scheduled time for %s: %s",
 					w.job.Name(),
@@ -220,21 +271,34 @@ func (w *Worker) runSchedule() {
 
 		case <-tick:
 			// check schedule every 5 seconds
-			if job := w.schedule.Pop(); job != nil {
-				job.ctrlChan <- jobStart
+			job := w.schedule.Pop()
+			if job == nil {
+				continue
+			}
+			// the manager rejects the report of a sync started before
+			// the jobs it depends on synced
+			if !w.dependenciesSynced() {
+				schedTime := time.Now().Add(dependencyRetry)
+				logger.Noticef("Job %s waits for its dependencies until %s", w.Name(), schedTime.Format("2006-01-02 15:04:05"))
+				w.schedule.AddJob(schedTime.Unix(), job)
+				w.updateSchedInfo(w.schedule.GetJob())
+				continue
 			}
+			job.ctrlChan <- jobStart
 		case <-w.exit:
-			// flush status update messages
+			// flush status update messages, a failure here is the sync
+			// killed by the halt
 			w.L.Lock()
 			defer w.L.Unlock()
 			for {
 				select {
 				case jobMsg := <-w.managerChan:
 					logger.Debugf("status update from %s", w.Name())
-					if jobMsg.status == v1beta1.Failed || jobMsg.status == v1beta1.Success {
+					if jobMsg.status == v1beta1.Success {
 						w.updateStatus(w.job, jobMsg)
 					}
 				default:
+					w.deregisterWorker()
 					return
 				}
 			}
@@ -264,17 +328,100 @@ func (w *Worker) registerWorker() {
 	}
 }
 
-func (w *Worker) updateStatus(job *mirrorJob, jobMsg jobMessage) {
+// checkCapabilities warns about a manager of another api version, the
+// worker goes on anyway as a newer manager still serves the old routes
+func (w *Worker) checkCapabilities() {
+	url := fmt.Sprintf("%s/capabilities", w.cfg.APIBase)
+	var caps internal.Capabilities
+	if _, err := w.GetJSON(url, &caps); err != nil {
+		// managers before /capabilities
+		logger.Noticef("Failed to fetch manager capabilities: %s", err.Error())
+		return
+	}
+	if caps.APIVersion != v1beta1.GroupVersion.String() {
+		logger.Warningf("Manager serves api %s, the worker expects %s", caps.APIVersion, v1beta1.GroupVersion.String())
+	}
+	if caps.Features.ReadOnly {
+		logger.Warningf("Manager at %s is read-only, reports of the worker will be rejected", w.cfg.APIBase)
+	}
+}
+
+// fallbackInterval is the interval of a job setting none when the
+// manager can't tell its default interval
+const fallbackInterval = 1440 * time.Minute
+
+// defaultInterval asks the manager for the interval of jobs setting none
+func (w *Worker) defaultInterval() time.Duration {
+	url := fmt.Sprintf("%s/config/default-interval", w.cfg.APIBase)
+	var d internal.DefaultInterval
+	if _, err := w.GetJSON(url, &d); err != nil || d.Interval <= 0 {
+		if err != nil {
+			logger.Warningf("Failed to fetch the default interval: %s", err.Error())
+		}
+		return fallbackInterval
+	}
+	return time.Duration(d.Interval) * time.Minute
+}
+
+// dependencyRetry is how long a scheduled sync waits for the jobs it
+// depends on before it's tried again
+const dependencyRetry = 5 * time.Minute
+
+// dependenciesSynced asks the manager whether the jobs the job depends on
+// synced, they're taken as synced when the manager can't tell
+func (w *Worker) dependenciesSynced() bool {
+	url := fmt.Sprintf("%s/job/%s/dependencies", w.cfg.APIBase, w.Name())
+	resp, err := w.HandleRequest(http.MethodGet, url, nil)
+	if err != nil {
+		logger.Warningf("Failed to check the dependencies: %s", err.Error())
+		return true
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode != http.StatusConflict
+}
+
+// deregisterWorker tells the manager the worker is shutting down cleanly
+func (w *Worker) deregisterWorker() {
+	url := fmt.Sprintf("%s/job/%s/offline", w.cfg.APIBase, w.Name())
+	logger.Debugf("deregister on manager url: %s", url)
+	if _, err := w.HandleRequest("POST", url, nil); err != nil {
+		logger.Errorf("Failed to deregister worker: %s", err.Error())
+	}
+}
+
+// updateStatus reports the job status, and returns the status stored
+// by the manager
+func (w *Worker) updateStatus(job *mirrorJob, jobMsg jobMessage) v1beta1.JobStatus {
 	p := job.provider
 	smsg := v1beta1.JobStatus{Status: jobMsg.status, Upstream: p.Upstream(), Size: job.size, ErrorMsg: jobMsg.msg}
+	// a manager resolving conflicts by timestamp discards it if it arrives
+	// after a later report
+	smsg.ReportedAt = time.Now().UnixMilli()
+	if jobMsg.status == v1beta1.Failed {
+		// report the log tail so it can be read without the worker
+		if tail, err := readFileTail(filepath.Join(w.cfg.LogDir, "latest"), internal.MaxLogTail); err == nil {
+			smsg.LogTail = internal.TruncateLogTail(tail, internal.MaxLogTail)
+		} else {
+			logger.Debugf("Read log tail failed, %s", err)
+		}
+	}
 	url := fmt.Sprintf(
 		"%s/job/%s", w.cfg.APIBase, w.Name(),
 	)
 	logger.Debugf("reporting on manager url: %s", url)
 	logger.Debugf("reporting data: %+v", smsg)
-	if _, err := w.HandleRequest("PATCH", url, smsg); err != nil {
+	resp, err := w.HandleRequest("PATCH", url, smsg)
+	if err != nil {
 		logger.Errorf("Failed to update mirror(%s) status: %s", w.Name(), err.Error())
+		return v1beta1.JobStatus{}
+	}
+	defer resp.Body.Close()
+
+	var status v1beta1.JobStatus
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&status) != nil {
+		return v1beta1.JobStatus{}
 	}
+	return status
 }
 
 func (w *Worker) updateSchedInfo(nextScheduled int64) {